@@ -0,0 +1,403 @@
+package sessions_mongo
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+//SessionExists reports whether a session with the given ID is stored, without loading
+//or decoding its data.  A malformed sessionID returns an *InvalidSessionIDErr.  Tombstones
+//kept by Options.SoftDelete don't count as stored, here and in GetSessionInfo.
+func (store *MongoDBStore) SessionExists(ctx context.Context, sessionID string) (bool, error) {
+	oid, err := parseSessionID(sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	err = store.collection.FindOne(ctx, store.excludeTombstones(store.idFilter(oid)), opts).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to check session existence",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return false, err
+	}
+
+	return true, nil
+}
+
+//GetSessionInfo returns the metadata of the stored session with the given ID.  The
+//encoded data is never read from the database.  Returns ErrSessionNotFound if no
+//such session is stored.
+func (store *MongoDBStore) GetSessionInfo(ctx context.Context, sessionID string) (SessionInfo, error) {
+	oid, err := parseSessionID(sessionID)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+
+	var s session
+	opts := options.FindOne().SetProjection(metadataProjection())
+	err = store.collection.FindOne(ctx, store.excludeTombstones(store.idFilter(oid)), opts).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return SessionInfo{}, ErrSessionNotFound
+	}
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to load session info",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return SessionInfo{}, err
+	}
+
+	return s.info(), nil
+}
+
+//IterateSessions streams the metadata of every stored session within the store's scope
+//to fn, without reading the encoded data or holding more than one batch in memory.  It
+//stops at, and returns, the first error returned by fn, or ctx.Err() once ctx is done.
+func (store *MongoDBStore) IterateSessions(ctx context.Context, fn func(SessionInfo) error) error {
+	opts := options.Find().SetProjection(metadataProjection())
+	cursor, err := store.collection.Find(ctx, store.scopedFilter(bson.M{}), opts)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to iterate sessions",
+			"error", err,
+		)
+		return err
+	}
+	//closing with ctx would leave the server cursor open once ctx is cancelled
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		var s session
+		if err = cursor.Decode(&s); err != nil {
+			return err
+		}
+		if err = fn(s.info()); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+//Query returns the metadata of the stored sessions matching filter within the store's
+//scope, as an escape hatch for queries the other methods don't cover.  The filter applies
+//to the stored fields, such as last_modified and the fields written by ExtraFields, not
+//to the decoded values, unless they are stored queryably with SerializationBSON under
+//`values`.  opts may sort, skip and limit the results; every matching session is held in
+//memory, so large result sets should be limited or streamed with IterateSessions.  Any
+//projection in opts is replaced by the metadata projection.
+func (store *MongoDBStore) Query(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]SessionInfo, error) {
+	scoped := bson.M{}
+	for k, v := range filter {
+		scoped[k] = v
+	}
+	findOpts := append(append([]*options.FindOptions(nil), opts...), options.Find().SetProjection(metadataProjection()))
+	cursor, err := store.collection.Find(ctx, store.scopedFilter(scoped), findOpts...)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to query sessions",
+			"error", err,
+		)
+		return nil, err
+	}
+	//closing with ctx would leave the server cursor open once ctx is cancelled
+	defer cursor.Close(context.Background())
+
+	var stored []session
+	if err = cursor.All(ctx, &stored); err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to read queried sessions",
+			"error", err,
+		)
+		return nil, err
+	}
+
+	infos := make([]SessionInfo, 0, len(stored))
+	for _, s := range stored {
+		infos = append(infos, s.info())
+	}
+
+	return infos, nil
+}
+
+//CountByFilter counts the stored sessions matching filter within the store's scope, e.g.
+//the sessions sharing the IP address written by ExtraFields to rate limit logins.  Like
+//Query, filter applies to the stored fields rather than the decoded values, and counting
+//is only cheap if an index covers the filtered fields.  Tombstones of Options.SoftDelete
+//are not counted unless filter matches on deleted_at; sessions past their expiry are
+//counted until they are removed.
+func (store *MongoDBStore) CountByFilter(ctx context.Context, filter bson.M) (int64, error) {
+	scoped := bson.M{}
+	for k, v := range filter {
+		scoped[k] = v
+	}
+	if _, ok := scoped["deleted_at"]; store.storeOptions.SoftDelete.Enabled && !ok {
+		scoped["deleted_at"] = bson.M{"$exists": false}
+	}
+
+	count, err := store.collection.CountDocuments(ctx, store.scopedFilter(scoped))
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to count sessions",
+			"error", err,
+		)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+//ForceExpire marks the stored session named name with the given ID as expired instead of
+//deleting it: expires_at is set to now and last_modified back by the TTL of name, so the
+//TTL index or DeleteExpiredSessions removes it on their next run.  Loads enforcing
+//TTLOptions.EnforceOnRead treat it as not found once GracePeriod and ClockSkew have
+//passed, while reads without enforcement keep seeing it until it is removed.
+//ErrSessionNotFound is returned if no such session is stored, including tombstones kept
+//by Options.SoftDelete, which expire after SoftDelete.RetainFor instead.
+func (store *MongoDBStore) ForceExpire(ctx context.Context, name, sessionID string) error {
+	oid, err := parseSessionID(sessionID)
+	if err != nil {
+		return err
+	}
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := store.now()
+	update := bson.M{"$set": bson.M{
+		"expires_at":    now,
+		"last_modified": now.Add(-store.ttlFor(name)),
+	}}
+
+	filter := store.excludeTombstones(store.namedFilter(oid, name))
+	var res *mongo.UpdateResult
+	err = store.withRetry(ctx, func() error {
+		res, err = c.UpdateOne(ctx, filter, update)
+		return err
+	})
+	store.uncache(sessionID)
+	if isUnacknowledgedWrite(err) {
+		return nil
+	}
+	if err != nil {
+		_ = level.Error(store.contextLogger(ctx)).Log(
+			"message", "failed to force session expiry",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+//RawDocument returns the stored document of the session with the given ID as is,
+//including its encoded data and any fields written by other tooling, e.g. to diagnose
+//why a session fails to decode.  Returns ErrSessionNotFound if no such session is stored.
+func (store *MongoDBStore) RawDocument(ctx context.Context, sessionID string) (bson.M, error) {
+	oid, err := parseSessionID(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	err = store.collection.FindOne(ctx, store.idFilter(oid)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to load raw session document",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+//SessionTimestamps returns when the stored session with the given ID was created and
+//last modified, without touching sess.Values.  createdAt is the zero time for sessions
+//stored before creation times were recorded.
+func (store *MongoDBStore) SessionTimestamps(ctx context.Context, sessionID string) (createdAt, lastModified time.Time, err error) {
+	info, err := store.GetSessionInfo(ctx, sessionID)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return info.CreatedAt, info.LastModified, nil
+}
+
+//SessionKeys loads and decodes the session stored under sessionID and returns the keys
+//of its values, sorted, without the values themselves, so they are safe to log.  The
+//session name is required because it is bound into the encoded values by the codecs.
+//Keys that aren't strings are formatted with fmt.Sprint.
+func (store *MongoDBStore) SessionKeys(ctx context.Context, name, sessionID string) ([]string, error) {
+	_, sess, err := store.loadStoredSession(ctx, name, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(sess.Values))
+	for k := range sess.Values {
+		keys = append(keys, fmt.Sprint(k))
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+//StoreStats is a snapshot of the sessions held by the store
+type StoreStats struct {
+	TotalSessions int64
+	//ModifiedLastHour is the number of sessions modified within the last hour
+	ModifiedLastHour int64
+	//DistinctUsers is the number of distinct values of Options.UserIDField across all
+	//sessions.  It is always 0 when no UserIDField is configured.
+	DistinctUsers int64
+}
+
+//Stats aggregates operational statistics about the stored sessions in a single query
+func (store *MongoDBStore) Stats(ctx context.Context) (StoreStats, error) {
+	countStage := bson.D{{Key: "$count", Value: "n"}}
+	facets := bson.M{
+		"total": bson.A{countStage},
+		"recent": bson.A{
+			bson.M{"$match": bson.M{"last_modified": bson.M{"$gte": store.now().Add(-time.Hour)}}},
+			countStage,
+		},
+	}
+	if userIDField := store.storeOptions.UserIDField; userIDField != "" {
+		facets["users"] = bson.A{
+			bson.M{"$match": bson.M{userIDField: bson.M{"$exists": true}}},
+			bson.M{"$group": bson.M{"_id": "$" + userIDField}},
+			countStage,
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: store.scopedFilter(bson.M{})}},
+		{{Key: "$facet", Value: facets}},
+	}
+
+	cursor, err := store.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to aggregate store stats",
+			"error", err,
+		)
+		return StoreStats{}, err
+	}
+
+	type count struct {
+		N int64 `bson:"n"`
+	}
+	var results []struct {
+		Total  []count `bson:"total"`
+		Recent []count `bson:"recent"`
+		Users  []count `bson:"users"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return StoreStats{}, err
+	}
+
+	var stats StoreStats
+	if len(results) == 0 {
+		return stats, nil
+	}
+	if len(results[0].Total) > 0 {
+		stats.TotalSessions = results[0].Total[0].N
+	}
+	if len(results[0].Recent) > 0 {
+		stats.ModifiedLastHour = results[0].Recent[0].N
+	}
+	if len(results[0].Users) > 0 {
+		stats.DistinctUsers = results[0].Users[0].N
+	}
+
+	return stats, nil
+}
+
+//ActiveUsers returns the number of distinct values of Options.UserIDField across the
+//sessions modified within the given duration, e.g. for a presence indicator.  It returns
+//ErrNoUserIDField if no UserIDField is configured.  An index on last_modified, such as
+//the TTL index, keeps the query from scanning the collection.
+func (store *MongoDBStore) ActiveUsers(ctx context.Context, within time.Duration) (int64, error) {
+	userIDField := store.storeOptions.UserIDField
+	if userIDField == "" {
+		return 0, ErrNoUserIDField
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: store.scopedFilter(bson.M{
+			"last_modified": bson.M{"$gte": store.now().Add(-within)},
+			userIDField:     bson.M{"$exists": true},
+		})}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + userIDField}}},
+		{{Key: "$count", Value: "n"}},
+	}
+
+	cursor, err := store.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to aggregate active users",
+			"error", err,
+		)
+		return 0, err
+	}
+
+	var results []struct {
+		N int64 `bson:"n"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	return results[0].N, nil
+}
+
+//metadataProjection excludes the potentially large encoded data and BSON values from query results
+func metadataProjection() bson.M {
+	return bson.M{"data": 0, "values": 0}
+}
+
+func isValidSessionID(sessionID string) bool {
+	_, err := primitive.ObjectIDFromHex(sessionID)
+	return err == nil
+}
+
+func parseSessionID(sessionID string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return primitive.NilObjectID, NewInvalidSessionIDErr(sessionID, err)
+	}
+
+	return oid, nil
+}
@@ -0,0 +1,44 @@
+package sessions_mongo
+
+import (
+	"sync"
+	"time"
+)
+
+//backgroundTasks tracks the goroutines owned by a store so Close can stop them
+type backgroundTasks struct {
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func newBackgroundTasks() *backgroundTasks {
+	return &backgroundTasks{stop: make(chan struct{})}
+}
+
+//every runs fn every interval until the tasks are closed
+func (bt *backgroundTasks) every(interval time.Duration, fn func()) {
+	bt.wg.Add(1)
+	go func() {
+		defer bt.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-bt.stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+//close stops every task and waits for them to return.  It is safe to call more than once.
+func (bt *backgroundTasks) close() {
+	bt.closeOnce.Do(func() {
+		close(bt.stop)
+	})
+	bt.wg.Wait()
+}
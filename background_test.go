@@ -0,0 +1,27 @@
+package sessions_mongo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackgroundTasks_close(t *testing.T) {
+	bt := newBackgroundTasks()
+	var runs int32
+	bt.every(time.Millisecond, func() {
+		atomic.AddInt32(&runs, 1)
+	})
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) > 0
+	}, time.Second, time.Millisecond)
+
+	bt.close()
+	stoppedAt := atomic.LoadInt32(&runs)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, stoppedAt, atomic.LoadInt32(&runs))
+
+	bt.close()
+}
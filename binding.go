@@ -0,0 +1,108 @@
+package sessions_mongo
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net"
+	"net/http"
+)
+
+type fingerprintKey struct{}
+
+//DefaultFingerprint is the Binding.FingerprintFunc used when none is set.  It combines the
+//User-Agent with the /24 (IPv4) or /64 (IPv6) network of the client address, so clients
+//moving within their network stay bound.  Behind a proxy, RemoteAddr is the proxy's
+//address; supply a FingerprintFunc reading the forwarded address instead.
+func DefaultFingerprint(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	network := host
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			network = ip4.Mask(net.CIDRMask(24, 32)).String()
+		} else {
+			network = ip.Mask(net.CIDRMask(64, 128)).String()
+		}
+	}
+
+	return r.UserAgent() + "\x00" + network
+}
+
+//fingerprint returns the hashed Binding fingerprint of r, or "" without Binding
+func (store *MongoDBStore) fingerprint(r *http.Request) string {
+	if !store.storeOptions.Binding.Enabled {
+		return ""
+	}
+
+	fingerprintFunc := store.storeOptions.Binding.FingerprintFunc
+	if fingerprintFunc == nil {
+		fingerprintFunc = DefaultFingerprint
+	}
+	sum := sha256.Sum256([]byte(fingerprintFunc(r)))
+
+	return hex.EncodeToString(sum[:])
+}
+
+//withFingerprint carries the hashed fingerprint of r to save
+func (store *MongoDBStore) withFingerprint(ctx context.Context, r *http.Request) context.Context {
+	if fp := store.fingerprint(r); fp != "" {
+		return context.WithValue(ctx, fingerprintKey{}, fp)
+	}
+
+	return ctx
+}
+
+func fingerprintFromContext(ctx context.Context) string {
+	fp, _ := ctx.Value(fingerprintKey{}).(string)
+	return fp
+}
+
+//ValidateBound checks that r comes from the client sess was bound to when it was created,
+//returning ErrSessionBindingMismatch if the fingerprint of r differs from the stored one.
+//New sessions, sessions stored before Binding was enabled and stores without Binding
+//always pass.  ErrSessionNotFound is returned if sess is no longer stored.
+func (store *MongoDBStore) ValidateBound(r *http.Request, sess *sessions.Session) error {
+	if !store.storeOptions.Binding.Enabled || sess.IsNew {
+		return nil
+	}
+
+	oid, err := parseSessionID(sess.ID)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	s, err := store.findNamedSession(ctx, oid, sess.Name())
+	if err == mongo.ErrNoDocuments {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		_ = level.Error(store.contextLogger(ctx)).Log(
+			"message", "failed to load session binding",
+			"session_id", sess.ID,
+			"error", err,
+		)
+		return err
+	}
+	if s.Fingerprint == "" {
+		return nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(s.Fingerprint), []byte(store.fingerprint(r))) != 1 {
+		_ = level.Warn(store.contextLogger(ctx)).Log(
+			"message", "session used from a client it isn't bound to",
+			"session_id", sess.ID,
+		)
+		return ErrSessionBindingMismatch
+	}
+
+	return nil
+}
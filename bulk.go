@@ -0,0 +1,94 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//SaveAll persists many sessions with a single unordered bulk write of upserts.  Sessions
+//without an ID are assigned one.  A failure to transform or write one session does not
+//prevent the others from being saved; if any session fails, a *SaveAllErr describing each
+//failure is returned.  A write concern error is reported as the failure of every session
+//that did not fail otherwise, as their writes may not be durable.  SaveAll always rewrites
+//the full session document and does not write cookies.  SaveAll is not supported with
+//LayoutSubdocument or CappedOptions.
+func (store *MongoDBStore) SaveAll(ctx context.Context, sessionsToSave []*sessions.Session) error {
+	if store.storeOptions.Layout == LayoutSubdocument {
+		return NewIncompatibleOptionsErr("SaveAll is not supported with LayoutSubdocument")
+	}
+	if store.storeOptions.CappedOptions.Enabled {
+		return NewIncompatibleOptionsErr("SaveAll is not supported with CappedOptions")
+	}
+
+	failures := make(map[string]error)
+	models := make([]mongo.WriteModel, 0, len(sessionsToSave))
+	modelSessions := make([]*sessions.Session, 0, len(sessionsToSave))
+
+	for _, sess := range sessionsToSave {
+		if sess.ID == "" {
+			sess.ID = primitive.NewObjectID().Hex()
+		}
+
+		s, err := store.toDocument(sess)
+		if err != nil {
+			failures[sess.ID] = err
+			continue
+		}
+
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(store.namedFilter(s.ObjectID(), s.Name)).
+			SetUpdate(updateDocFromSession(s, store.unsetFields()...)).
+			SetUpsert(true))
+		modelSessions = append(modelSessions, sess)
+	}
+
+	failedIndexes := make(map[int]bool)
+	if len(models) > 0 {
+		_, err := store.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		for _, sess := range modelSessions {
+			store.uncache(sess.ID)
+		}
+		if bwe, ok := err.(mongo.BulkWriteException); ok {
+			for _, writeErr := range bwe.WriteErrors {
+				failedIndexes[writeErr.Index] = true
+				failures[modelSessions[writeErr.Index].ID] = writeErr
+			}
+			if bwe.WriteConcernError != nil {
+				for i, sess := range modelSessions {
+					if !failedIndexes[i] {
+						failedIndexes[i] = true
+						failures[sess.ID] = *bwe.WriteConcernError
+					}
+				}
+			}
+		} else if err != nil && !isUnacknowledgedWrite(err) {
+			_ = level.Error(store.logger).Log(
+				"message", "failed to bulk save sessions",
+				"count", len(models),
+				"error", err,
+			)
+			return err
+		}
+	}
+
+	for i, sess := range modelSessions {
+		if !failedIndexes[i] {
+			sess.IsNew = false
+		}
+	}
+
+	if len(failures) > 0 {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to save some sessions",
+			"failed", len(failures),
+			"total", len(sessionsToSave),
+		)
+		return NewSaveAllErr(failures)
+	}
+
+	return nil
+}
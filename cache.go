@@ -0,0 +1,213 @@
+package sessions_mongo
+
+import (
+	"container/list"
+	"go.mongodb.org/mongo-driver/bson"
+	"sync"
+	"time"
+)
+
+//Cache holds recently loaded session documents in front of the collection, keyed by
+//session ID.  Values are the BSON encoded documents as stored, so a Cache never holds
+//decoded session values.  Implementations must be safe for concurrent use and should
+//expire entries after a period much shorter than the session TTL, as that period bounds
+//how stale a cached session may be.
+type Cache interface {
+	Get(sessionID string) ([]byte, bool)
+	Set(sessionID string, document []byte)
+	Delete(sessionID string)
+}
+
+//StaleCache is a Cache also returning entries past their expiry, as long as they were
+//not deleted, for Options.ServeStaleOnError
+type StaleCache interface {
+	Cache
+	GetStale(sessionID string) ([]byte, bool)
+}
+
+//lruCache is an in-process Cache evicting the least recently used entry once full
+type lruCache struct {
+	mu       sync.Mutex
+	size     int
+	ttl      time.Duration
+	staleTTL time.Duration
+	entries  map[string]*list.Element
+	recency  *list.List
+	timeFunc func() time.Time
+}
+
+type lruEntry struct {
+	sessionID  string
+	document   []byte
+	expiresAt  time.Time
+	staleUntil time.Time
+}
+
+//NewLRUCache returns an in-process Cache holding up to size session documents, each for
+//at most ttl after it was loaded
+func NewLRUCache(size int, ttl time.Duration) Cache {
+	return &lruCache{
+		size:     size,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element, size),
+		recency:  list.New(),
+		timeFunc: time.Now,
+	}
+}
+
+//NewStaleLRUCache returns an in-process StaleCache holding up to size session documents,
+//each served by Get for at most ttl and by GetStale for at most staleTTL after it was
+//loaded.  Expired entries keep taking up room until staleTTL has passed as well.
+func NewStaleLRUCache(size int, ttl, staleTTL time.Duration) StaleCache {
+	c := NewLRUCache(size, ttl).(*lruCache)
+	c.staleTTL = staleTTL
+	return c
+}
+
+func (c *lruCache) Get(sessionID string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[sessionID]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	now := c.timeFunc()
+	if !now.Before(entry.expiresAt) {
+		if !now.Before(entry.staleUntil) {
+			c.remove(elem)
+		}
+		return nil, false
+	}
+	c.recency.MoveToFront(elem)
+
+	return entry.document, true
+}
+
+func (c *lruCache) GetStale(sessionID string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[sessionID]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !c.timeFunc().Before(entry.staleUntil) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	return entry.document, true
+}
+
+func (c *lruCache) Set(sessionID string, document []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.timeFunc()
+	expiresAt := now.Add(c.ttl)
+	staleUntil := expiresAt
+	if c.staleTTL > c.ttl {
+		staleUntil = now.Add(c.staleTTL)
+	}
+	if elem, ok := c.entries[sessionID]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.document = document
+		entry.expiresAt = expiresAt
+		entry.staleUntil = staleUntil
+		c.recency.MoveToFront(elem)
+		return
+	}
+
+	c.entries[sessionID] = c.recency.PushFront(&lruEntry{
+		sessionID:  sessionID,
+		document:   document,
+		expiresAt:  expiresAt,
+		staleUntil: staleUntil,
+	})
+	for c.recency.Len() > c.size {
+		c.remove(c.recency.Back())
+	}
+}
+
+func (c *lruCache) Delete(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[sessionID]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *lruCache) remove(elem *list.Element) {
+	c.recency.Remove(elem)
+	delete(c.entries, elem.Value.(*lruEntry).sessionID)
+}
+
+//cachedSession returns the cached document of the session with the given ID
+func (store *MongoDBStore) cachedSession(sessionID string) (session, bool) {
+	if store.storeOptions.Cache == nil {
+		return session{}, false
+	}
+
+	document, ok := store.storeOptions.Cache.Get(sessionID)
+	if !ok {
+		return session{}, false
+	}
+	var s session
+	if err := bson.Unmarshal(document, &s); err != nil {
+		store.storeOptions.Cache.Delete(sessionID)
+		return session{}, false
+	}
+
+	return s, true
+}
+
+//staleSession returns the cached document of the session with the given ID regardless of
+//its expiry, if Options.ServeStaleOnError is set
+func (store *MongoDBStore) staleSession(sessionID string) (session, bool) {
+	cache, ok := store.storeOptions.Cache.(StaleCache)
+	if !store.storeOptions.ServeStaleOnError || !ok {
+		return session{}, false
+	}
+
+	document, ok := cache.GetStale(sessionID)
+	if !ok {
+		return session{}, false
+	}
+	var s session
+	if err := bson.Unmarshal(document, &s); err != nil {
+		cache.Delete(sessionID)
+		return session{}, false
+	}
+
+	return s, true
+}
+
+func (store *MongoDBStore) cacheSession(s session) {
+	if store.storeOptions.Cache == nil {
+		return
+	}
+
+	document, err := bson.Marshal(s)
+	if err != nil {
+		return
+	}
+	store.storeOptions.Cache.Set(s.ID.Hex(), document)
+}
+
+//uncache removes a session from the cache after it was written or deleted
+func (store *MongoDBStore) uncache(sessionID string) {
+	if store.storeOptions.Cache != nil {
+		store.storeOptions.Cache.Delete(sessionID)
+	}
+}
+
+//invalidated notifies Options.OnInvalidate that the session was removed from the collection
+func (store *MongoDBStore) invalidated(sessionID string) {
+	if store.storeOptions.OnInvalidate != nil {
+		store.storeOptions.OnInvalidate(sessionID)
+	}
+}
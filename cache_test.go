@@ -0,0 +1,58 @@
+package sessions_mongo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Now()
+	cache := NewLRUCache(2, time.Second).(*lruCache)
+	cache.timeFunc = func() time.Time { return now }
+
+	cache.Set("a", []byte("a"))
+	cache.Set("b", []byte("b"))
+	_, ok := cache.Get("a")
+	assert.True(t, ok)
+
+	cache.Set("c", []byte("c"))
+	_, ok = cache.Get("b")
+	assert.False(t, ok, "least recently used entry should be evicted")
+	document, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), document)
+
+	cache.Delete("a")
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok = cache.Get("c")
+	assert.False(t, ok, "expired entry should not be returned")
+	assert.Equal(t, 0, cache.recency.Len())
+}
+
+func TestStaleLRUCache(t *testing.T) {
+	now := time.Now()
+	cache := NewStaleLRUCache(2, time.Second, time.Minute).(*lruCache)
+	cache.timeFunc = func() time.Time { return now }
+
+	cache.Set("a", []byte("a"))
+	now = now.Add(time.Second)
+	_, ok := cache.Get("a")
+	assert.False(t, ok, "expired entry should not be returned")
+	document, ok := cache.GetStale("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), document)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.GetStale("a")
+	assert.False(t, ok, "entry past its stale period should not be returned")
+	assert.Equal(t, 0, cache.recency.Len())
+
+	cache.Set("b", []byte("b"))
+	cache.Delete("b")
+	_, ok = cache.GetStale("b")
+	assert.False(t, ok)
+}
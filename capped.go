@@ -0,0 +1,103 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//ensureCappedCollection creates collection as a capped collection, or verifies that the
+//existing collection is capped
+func ensureCappedCollection(ctx context.Context, collection *mongo.Collection, opts CappedOptions) error {
+	db := collection.Database()
+	create := bson.D{
+		{Key: "create", Value: collection.Name()},
+		{Key: "capped", Value: true},
+		{Key: "size", Value: opts.SizeBytes},
+	}
+	if opts.MaxDocs > 0 {
+		create = append(create, bson.E{Key: "max", Value: opts.MaxDocs})
+	}
+
+	err := db.RunCommand(ctx, create).Err()
+	if err == nil || !isNamespaceExistsError(err) {
+		return err
+	}
+
+	var stats struct {
+		Capped bool `bson:"capped"`
+	}
+	err = db.RunCommand(ctx, bson.D{{Key: "collStats", Value: collection.Name()}}).Decode(&stats)
+	if err != nil {
+		return err
+	}
+	if !stats.Capped {
+		return NewIncompatibleOptionsErr("CappedOptions are enabled but the existing collection is not capped")
+	}
+
+	return nil
+}
+
+//replaceCappedSession saves sess to a capped collection, where MongoDB rejects updates
+//changing the size of a document, by deleting the stored document and inserting sess in
+//its place, keeping the created_at and fingerprint of the deleted one.  With mustExist, as
+//for WriteModeStrict, a missing session returns ErrSessionNotFound instead of being
+//inserted.
+func (store *MongoDBStore) replaceCappedSession(ctx context.Context, sess session, mustExist bool) error {
+	logger := store.contextLogger(ctx)
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return err
+	}
+
+	var previous session
+	opts := options.FindOneAndDelete().SetProjection(bson.M{"created_at": 1, "fingerprint": 1})
+	err = store.withRetry(ctx, func() error {
+		return c.FindOneAndDelete(ctx, store.namedFilter(sess.ObjectID(), sess.Name), opts).Decode(&previous)
+	})
+	replaced := err == nil
+	if err == mongo.ErrNoDocuments && mustExist {
+		_ = level.Info(logger).Log(
+			"message", "session to update no longer exists",
+			"session_id", sess.ID.String(),
+		)
+		return ErrSessionNotFound
+	}
+	if err != nil && err != mongo.ErrNoDocuments && !isUnacknowledgedWrite(err) {
+		_ = level.Error(logger).Log(
+			"message", "failed to remove session to replace from database",
+			"session_id", sess.ID.String(),
+			"error", err,
+		)
+		return err
+	}
+	if replaced {
+		if !previous.CreatedAt.IsZero() {
+			sess.CreatedAt = previous.CreatedAt
+		}
+		//the fingerprint is never overwritten, see updateDocFromSession
+		sess.Fingerprint = previous.Fingerprint
+	}
+
+	_, err = c.InsertOne(ctx, sess)
+	if isUnacknowledgedWrite(err) {
+		return nil
+	}
+	if err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to insert session in database",
+			"session_id", sess.ID.String(),
+			"error", err,
+		)
+		return err
+	}
+
+	if replaced {
+		recordSaveResult(ctx, SaveUpdated)
+	} else {
+		recordSaveResult(ctx, SaveCreated)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package sessions_mongo
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"sync"
+)
+
+type requestSavesKey struct{}
+
+//requestSaves records the hash of the values of every session saved during a request,
+//so Save can skip writing a session again with identical values
+type requestSaves struct {
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+//requestSaves returns the saves recorded for r, attaching a new record to the context of
+//r like sessions.GetRegistry does, or nil if Options.DisableSaveCoalescing is set
+func (store *MongoDBStore) requestSaves(r *http.Request) *requestSaves {
+	if store.storeOptions.DisableSaveCoalescing {
+		return nil
+	}
+
+	if saves, ok := r.Context().Value(requestSavesKey{}).(*requestSaves); ok {
+		return saves
+	}
+	saves := &requestSaves{hashes: make(map[string]string)}
+	*r = *r.WithContext(context.WithValue(r.Context(), requestSavesKey{}, saves))
+
+	return saves
+}
+
+func requestSaveKey(sess *sessions.Session) string {
+	return sess.Name() + "\x00" + sess.ID
+}
+
+//unchanged reports whether sess was already saved during the request with values
+//hashing to hash
+func (rs *requestSaves) unchanged(sess *sessions.Session, hash string) bool {
+	if rs == nil || hash == "" {
+		return false
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return rs.hashes[requestSaveKey(sess)] == hash
+}
+
+func (rs *requestSaves) record(sess *sessions.Session, hash string) {
+	if rs == nil || hash == "" {
+		return
+	}
+
+	rs.mu.Lock()
+	rs.hashes[requestSaveKey(sess)] = hash
+	rs.mu.Unlock()
+}
+
+func (rs *requestSaves) forget(sess *sessions.Session) {
+	if rs == nil {
+		return
+	}
+
+	rs.mu.Lock()
+	delete(rs.hashes, requestSaveKey(sess))
+	rs.mu.Unlock()
+}
+
+//contentHash returns a hash of everything Save writes for sess, or "" if it can't be
+//hashed, in which case the save is not coalesced.  Saving a new session inserts it in
+//WriteModeStrict, so IsNew is part of the content.
+func (store *MongoDBStore) contentHash(sess *sessions.Session) string {
+	valuesHash, err := hashValues(sess.Values)
+	if err != nil {
+		return ""
+	}
+
+	var extraHash string
+	if store.storeOptions.ExtraFields != nil {
+		extra := make(map[interface{}]interface{})
+		for k, v := range store.storeOptions.ExtraFields(sess) {
+			extra[k] = v
+		}
+		if extraHash, err = hashValues(extra); err != nil {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("%t:%s:%s", sess.IsNew, valuesHash, extraHash)
+}
@@ -0,0 +1,168 @@
+package sessions_mongo
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//fakeCollection records UpdateOne calls and fails BulkWrite with err; any other method
+//panics
+type fakeCollection struct {
+	collection
+	filters []interface{}
+	updates []interface{}
+	err     error
+}
+
+func (fc *fakeCollection) UpdateOne(_ context.Context, filter, update interface{}, _ ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	fc.filters = append(fc.filters, filter)
+	fc.updates = append(fc.updates, update)
+	return &mongo.UpdateResult{MatchedCount: 1}, fc.err
+}
+
+func (fc *fakeCollection) BulkWrite(context.Context, []mongo.WriteModel, ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	return &mongo.BulkWriteResult{}, fc.err
+}
+
+func TestMongoDBStore_SaveAll_WriteConcernError(t *testing.T) {
+	wce := &mongo.WriteConcernError{Name: "WriteConcernFailed", Code: 64, Message: "waiting for replication timed out"}
+	fake := &fakeCollection{err: mongo.BulkWriteException{WriteConcernError: wce}}
+	store := &MongoDBStore{
+		collection:     fake,
+		ttl:            time.Minute,
+		codecs:         securecookie.CodecsFromPairs([]byte("abcdefghijklmnop")),
+		defaultOptions: &sessions.Options{MaxAge: 60},
+		storeOptions:   Options{TTLOptions: TTLOptions{TTL: time.Minute}},
+		logger:         log.NewNopLogger(),
+	}
+
+	sess := sessions.NewSession(store, "key")
+	sess.IsNew = true
+	sess.Values["user_id"] = "abc"
+	err := store.SaveAll(context.Background(), []*sessions.Session{sess})
+	require.IsType(t, &SaveAllErr{}, err)
+	assert.Equal(t, map[string]error{sess.ID: *wce}, err.(*SaveAllErr).Failures())
+	assert.True(t, sess.IsNew, "a session whose write may not be durable should stay new")
+}
+
+func TestMongoDBStore_Save_FakeCollection(t *testing.T) {
+	fake := &fakeCollection{}
+	store := &MongoDBStore{
+		collection:     fake,
+		ttl:            time.Minute,
+		codecs:         securecookie.CodecsFromPairs([]byte("abcdefghijklmnop")),
+		defaultOptions: &sessions.Options{MaxAge: 60},
+		storeOptions:   Options{TTLOptions: TTLOptions{TTL: time.Minute}, BaseFilter: bson.M{"tenant": "a"}},
+		logger:         log.NewNopLogger(),
+	}
+
+	sess := sessions.NewSession(store, "key")
+	sess.Options = &sessions.Options{MaxAge: 60}
+	sess.Values["user_id"] = "abc"
+	require.Nil(t, store.Save(&http.Request{}, nil, sess))
+
+	require.Len(t, fake.filters, 1)
+	filter := fake.filters[0].(bson.M)
+	assert.Equal(t, "a", filter["tenant"])
+	assert.Equal(t, sess.ID, filter["_id"].(documentID).Hex())
+	set := fake.updates[0].(bson.M)["$set"].(bson.M)
+	assert.NotEmpty(t, set["data"])
+	assert.Equal(t, "a", set["tenant"])
+
+	sess.Values["user_id"] = "updated"
+	result, err := store.SaveWithResult(&http.Request{}, nil, sess)
+	require.Nil(t, err)
+	assert.Equal(t, SaveUpdated, result)
+
+	fake.err = errors.New("write failed")
+	sess.Values["user_id"] = "def"
+	assert.Equal(t, fake.err, store.Save(&http.Request{}, nil, sess))
+}
+
+func TestMongoDBStore_Save_UnacknowledgedWrite(t *testing.T) {
+	fake := &fakeCollection{err: mongo.ErrUnacknowledgedWrite}
+	store := &MongoDBStore{
+		collection:     fake,
+		ttl:            time.Minute,
+		codecs:         securecookie.CodecsFromPairs([]byte("abcdefghijklmnop")),
+		defaultOptions: &sessions.Options{MaxAge: 60},
+		storeOptions:   Options{TTLOptions: TTLOptions{TTL: time.Minute}},
+		logger:         log.NewNopLogger(),
+	}
+
+	sess := sessions.NewSession(store, "key")
+	sess.Options = &sessions.Options{MaxAge: 60}
+	sess.Values["user_id"] = "abc"
+	result, err := store.SaveWithResult(&http.Request{}, nil, sess)
+	assert.Nil(t, err)
+	assert.Equal(t, SaveNotWritten, result)
+	assert.False(t, sess.IsNew)
+}
+
+func TestMongoDBStore_Save_KeepCookieOnDeleteError(t *testing.T) {
+	fake := &fakeCollection{err: errors.New("delete failed")}
+	store := &MongoDBStore{
+		collection:     fake,
+		ttl:            time.Minute,
+		codecs:         securecookie.CodecsFromPairs([]byte("abcdefghijklmnop")),
+		defaultOptions: &sessions.Options{MaxAge: 60},
+		storeOptions: Options{
+			TTLOptions: TTLOptions{TTL: time.Minute},
+			SoftDelete: SoftDeleteOptions{Enabled: true, RetainFor: time.Hour},
+		},
+		logger: log.NewNopLogger(),
+	}
+
+	sess := sessions.NewSession(store, "key")
+	sess.ID = primitive.NewObjectID().Hex()
+	sess.IsNew = false
+	sess.Options = &sessions.Options{MaxAge: -1}
+
+	w := httptest.NewRecorder()
+	assert.Equal(t, fake.err, store.Save(&http.Request{}, w, sess))
+	assert.NotEmpty(t, w.Header().Get("Set-Cookie"))
+
+	store.storeOptions.KeepCookieOnDeleteError = true
+	w = httptest.NewRecorder()
+	assert.Equal(t, fake.err, store.Save(&http.Request{}, w, sess))
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+}
+
+//fakeIndexes fails every index creation with err
+type fakeIndexes struct {
+	err error
+}
+
+func (fi fakeIndexes) CreateOne(context.Context, mongo.IndexModel, ...*options.CreateIndexesOptions) (string, error) {
+	return "", fi.err
+}
+
+func TestEnsureConfiguredTTLIndex_Unauthorized(t *testing.T) {
+	unauthorized := fakeIndexes{err: mongo.CommandError{Code: 13, Name: "Unauthorized"}}
+	ttlOptions := TTLOptions{EnsureTTLIndex: true, TTL: time.Minute}
+
+	_, err := ensureConfiguredTTLIndex(context.Background(), unauthorized, ttlOptions, log.NewNopLogger())
+	assert.True(t, isUnauthorizedError(err))
+
+	ttlOptions.IndexBestEffort = true
+	ensured, err := ensureConfiguredTTLIndex(context.Background(), unauthorized, ttlOptions, log.NewNopLogger())
+	require.Nil(t, err)
+	assert.False(t, ensured, "a tolerated authorization error leaves the index unconfirmed")
+
+	ensured, err = ensureConfiguredTTLIndex(context.Background(), fakeIndexes{}, ttlOptions, log.NewNopLogger())
+	require.Nil(t, err)
+	assert.True(t, ensured)
+}
@@ -0,0 +1,47 @@
+package sessions_mongo
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//EncryptedValuesSchema returns a JSON schema encrypting the values field of session
+//documents with MongoDB client-side field level encryption (CSFLE), using the data key
+//keyID and the random algorithm.  It is meant for the schema map of the client's
+//auto-encryption options, keyed by the "<database>.<collection>" namespace:
+//
+//	autoEncryption := options.AutoEncryption().
+//		SetKeyVaultNamespace("encryption.__keyVault").
+//		SetKmsProviders(kmsProviders).
+//		SetSchemaMap(map[string]interface{}{
+//			"app.sessions": sessions_mongo.EncryptedValuesSchema(keyID),
+//		})
+//	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetAutoEncryptionOptions(autoEncryption))
+//
+//The store must use SerializationBSON, so that sess.Values are written as a plain BSON
+//document for the driver to encrypt and decrypt transparently, and the securecookie
+//codecs are only applied to the session ID.  With SerializationGob the values are
+//already encrypted by the codecs and stored in the data field, which this schema leaves
+//as is.  Values stored before encryption was enabled stay unencrypted until the session
+//is saved again; gob encoded sessions can be rewritten with MigrateFormat through a store
+//using the encrypting client.
+//
+//Encrypted values cannot be used in filters, Stats uses an aggregation stage that
+//auto-encryption does not support, and SkipUnchangedWrites stores an unencrypted hash of
+//the values.
+func EncryptedValuesSchema(keyID primitive.Binary) bson.M {
+	return bson.M{
+		"bsonType": "object",
+		"encryptMetadata": bson.M{
+			"keyId": bson.A{keyID},
+		},
+		"properties": bson.M{
+			"values": bson.M{
+				"encrypt": bson.M{
+					"bsonType":  "object",
+					"algorithm": "AEAD_AES_256_CBC_HMAC_SHA_512-Random",
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package sessions_mongo
+
+import (
+	"sync/atomic"
+)
+
+//decodeFailureCounter counts stored sessions load failed to decode.  It lives behind a
+//pointer so copies of the store share it.
+type decodeFailureCounter struct {
+	count uint64
+}
+
+//DecodeFailures returns the number of stored sessions the store failed to decode while
+//loading them since it was created, e.g. to alert when a key rotation goes wrong.  Stores
+//derived with the With methods share the count of the store they were derived from.
+func (store *MongoDBStore) DecodeFailures() uint64 {
+	if store.decodeFailures == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&store.decodeFailures.count)
+}
+
+//decodeFailed records that the values of the stored session with the given ID could not
+//be decoded and reports it to Options.OnDecodeFailure
+func (store *MongoDBStore) decodeFailed(sessionID string, err error) {
+	if store.decodeFailures != nil {
+		atomic.AddUint64(&store.decodeFailures.count, 1)
+	}
+	if store.storeOptions.OnDecodeFailure != nil {
+		store.storeOptions.OnDecodeFailure(sessionID, err)
+	}
+}
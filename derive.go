@@ -0,0 +1,155 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+//WithTTL returns a copy of the store that expires sessions after ttl, sharing the
+//collection, codecs and logger of the original without re-running NewMongoDBStore.
+//ttl is validated like TTLOptions.TTL, so a ttl that isn't positive returns an
+//*InvalidTTLErr and one not longer than TTLOptions.Jitter an *IncompatibleOptionsErr.
+//If the original store ensured a TTL index, that index still deletes sessions after the
+//original TTL, so a longer ttl should be combined with TTLOptions.EnforceOnRead or the
+//sweeper rather than the index.  The default cookie options, including MaxAge, are left
+//unchanged.
+//
+//Derived stores share their background tasks with the original, so closing any of them
+//stops the sweeper for all of them.
+func (store *MongoDBStore) WithTTL(ttl time.Duration) (*MongoDBStore, error) {
+	derived := store.clone()
+	derived.ttl = ttl
+	derived.storeOptions.TTLOptions.TTL = ttl
+	if err := derived.storeOptions.Validate(); err != nil {
+		return nil, err
+	}
+
+	return derived, nil
+}
+
+//WithSessionOptions returns a copy of the store that uses a copy of opts as the
+//default options of new sessions.  Like in NewMongoDBStore, nil opts default to a Path of
+//"/" and a MaxAge of the TTL.  See WithTTL for what derived stores share.
+func (store *MongoDBStore) WithSessionOptions(opts *sessions.Options) *MongoDBStore {
+	derived := store.clone()
+	if opts == nil {
+		derived.defaultOptions = &sessions.Options{
+			Path:   "/",
+			MaxAge: int(derived.ttl.Seconds()),
+		}
+	} else {
+		derived.defaultOptions = derefOpts(opts)
+	}
+
+	return derived
+}
+
+//WithDefaultOptions is WithSessionOptions, e.g. for turning on Secure for the cookies of
+//new sessions behind a feature flag without restarting.  Sessions that were already
+//issued keep their options until they are saved with others.
+func (store *MongoDBStore) WithDefaultOptions(opts *sessions.Options) *MongoDBStore {
+	return store.WithSessionOptions(opts)
+}
+
+//WithBaseFilter returns a copy of the store scoped by filter instead of the original
+//Options.BaseFilter.  Unlike NewMongoDBStore, the keys of filter are not validated.  See
+//WithTTL for what derived stores share.
+func (store *MongoDBStore) WithBaseFilter(filter bson.M) *MongoDBStore {
+	derived := store.clone()
+	derived.storeOptions.BaseFilter = copyFields(filter)
+
+	return derived
+}
+
+//WithCodecs returns a copy of the store that uses codecs instead of the original codecs,
+//e.g. for staged key rotation: derive a store with the new codec first and the old ones
+//after it, so sessions are encoded with the new key while existing ones still decode,
+//then drop the old codecs once their sessions have expired or been rewritten.  Like
+//securecookie.EncodeMulti and DecodeMulti, values are encoded with the first codec and
+//decoded with the first codec that accepts them.  The derived store keeps its own copy
+//of codecs; Codecs returns the current ones.  See WithTTL for what derived stores share.
+func (store *MongoDBStore) WithCodecs(codecs ...securecookie.Codec) *MongoDBStore {
+	derived := store.clone()
+	derived.codecs = append([]securecookie.Codec(nil), codecs...)
+
+	return derived
+}
+
+//WithCollection returns a copy of the store that uses c, e.g. to switch to a warmed up
+//collection during a migration.  c is prepared like the collection passed to
+//NewMongoDBStore: the connection is checked, the configured read and write settings
+//are applied and, if enabled, the capped collection, TTL index and AdditionalIndexes are
+//ensured.  The original store is unaffected.  See WithTTL for what derived stores share.
+func (store *MongoDBStore) WithCollection(c *mongo.Collection) (*MongoDBStore, error) {
+	collection, ttlIndexEnsured, err := prepareCollection(context.Background(), c, store.storeOptions, store.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	derived := store.clone()
+	derived.collection = collection
+	derived.ttlIndex = newTTLIndexState(ttlIndexEnsured)
+
+	return derived, nil
+}
+
+//clone returns a shallow copy of the store whose mutable options are copied, so
+//modifying the copy never affects the original
+func (store *MongoDBStore) clone() *MongoDBStore {
+	derived := *store
+	derived.codecs = append([]securecookie.Codec(nil), store.codecs...)
+	if store.defaultOptions != nil {
+		derived.defaultOptions = derefOpts(store.defaultOptions)
+	}
+	derived.storeOptions = store.storeOptions.clone()
+	derived.logger = store.cloneLogger()
+
+	return &derived
+}
+
+//clone returns a copy of o that shares no maps or slices with o
+func (o Options) clone() Options {
+	c := o
+	c.BaseFilter = copyFields(o.BaseFilter)
+	c.LoadProjection = copyFields(o.LoadProjection)
+	if o.TTLOptions.TTLByName != nil {
+		c.TTLOptions.TTLByName = make(map[string]time.Duration, len(o.TTLOptions.TTLByName))
+		for name, ttl := range o.TTLOptions.TTLByName {
+			c.TTLOptions.TTLByName[name] = ttl
+		}
+	}
+	if o.ValueTypes != nil {
+		c.ValueTypes = make(map[string]interface{}, len(o.ValueTypes))
+		for key, prototype := range o.ValueTypes {
+			c.ValueTypes[key] = prototype
+		}
+	}
+	if o.AdditionalIndexes != nil {
+		c.AdditionalIndexes = append([]mongo.IndexModel(nil), o.AdditionalIndexes...)
+	}
+	if o.LegacyCookieNames != nil {
+		c.LegacyCookieNames = append([]string(nil), o.LegacyCookieNames...)
+	}
+	if o.LegacyOptions.RemovedFields != nil {
+		c.LegacyOptions.RemovedFields = append([]string(nil), o.LegacyOptions.RemovedFields...)
+	}
+
+	return c
+}
+
+func copyFields(fields bson.M) bson.M {
+	if fields == nil {
+		return nil
+	}
+
+	c := make(bson.M, len(fields))
+	for k, v := range fields {
+		c[k] = v
+	}
+
+	return c
+}
@@ -0,0 +1,101 @@
+package sessions_mongo
+
+import (
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestMongoDBStore_WithTTL(t *testing.T) {
+	original := &MongoDBStore{
+		ttl:            time.Hour,
+		codecs:         securecookie.CodecsFromPairs([]byte("secret-key")),
+		defaultOptions: &sessions.Options{Path: "/", MaxAge: 3600},
+		storeOptions: Options{
+			TTLOptions:    TTLOptions{TTL: time.Hour, TTLByName: map[string]time.Duration{"a": time.Minute}},
+			BaseFilter:    bson.M{"tenant": "a"},
+			LegacyOptions: LegacyOptions{RemovedFields: []string{"old"}},
+		},
+		background: newBackgroundTasks(),
+	}
+
+	derived, err := original.WithTTL(time.Minute)
+	require.Nil(t, err)
+	derived.storeOptions.TTLOptions.TTLByName["a"] = time.Second
+	derived.storeOptions.BaseFilter["tenant"] = "b"
+	derived.storeOptions.LegacyOptions.RemovedFields[0] = "new"
+	derived.defaultOptions.Path = "/derived"
+	derived.codecs[0] = nil
+
+	assert.Equal(t, time.Minute, derived.ttl)
+	assert.Equal(t, time.Minute, derived.storeOptions.TTLOptions.TTL)
+	assert.Equal(t, time.Hour, original.ttl)
+	assert.Equal(t, time.Hour, original.storeOptions.TTLOptions.TTL)
+	assert.Equal(t, time.Minute, original.storeOptions.TTLOptions.TTLByName["a"])
+	assert.Equal(t, "a", original.storeOptions.BaseFilter["tenant"])
+	assert.Equal(t, "old", original.storeOptions.LegacyOptions.RemovedFields[0])
+	assert.Equal(t, "/", original.defaultOptions.Path)
+	assert.NotNil(t, original.codecs[0])
+	assert.Same(t, original.background, derived.background)
+
+	_, err = original.WithTTL(0)
+	assert.Equal(t, NewInvalidTTLErr(0), err)
+	_, err = original.WithTTL(-time.Minute)
+	assert.Equal(t, NewInvalidTTLErr(-time.Minute), err)
+
+	original.storeOptions.TTLOptions.Jitter = time.Minute
+	_, err = original.WithTTL(30 * time.Second)
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+}
+
+func TestMongoDBStore_WithCodecs(t *testing.T) {
+	oldCodecs := securecookie.CodecsFromPairs([]byte("old-secret-key"))
+	original := &MongoDBStore{
+		codecs:     oldCodecs,
+		background: newBackgroundTasks(),
+	}
+	sess := sessions.NewSession(original, "key")
+	sess.ID = "5f6c6a7b8c9d0e1f2a3b4c5d"
+	encodedByOld, err := original.EncodedID(sess)
+	assert.Nil(t, err)
+
+	newCodecs := securecookie.CodecsFromPairs([]byte("new-secret-key"))
+	rotating := []securecookie.Codec{newCodecs[0], oldCodecs[0]}
+	derived := original.WithCodecs(rotating...)
+	rotating[0] = nil
+
+	assert.Equal(t, []securecookie.Codec{newCodecs[0], oldCodecs[0]}, derived.Codecs())
+	assert.Equal(t, oldCodecs, original.Codecs())
+
+	decoded, err := derived.idCodec().Decode("key", encodedByOld)
+	assert.Nil(t, err, "the old codec should still decode")
+	assert.Equal(t, sess.ID, decoded)
+
+	encodedByNew, err := derived.EncodedID(sess)
+	assert.Nil(t, err)
+	_, err = original.idCodec().Decode("key", encodedByNew)
+	assert.NotNil(t, err, "the new codec should be primary")
+}
+
+func TestMongoDBStore_WithDefaultOptions(t *testing.T) {
+	opts := &sessions.Options{Path: "/", MaxAge: 60}
+	original := &MongoDBStore{
+		ttl:            time.Hour,
+		defaultOptions: opts,
+		background:     newBackgroundTasks(),
+	}
+
+	secure := &sessions.Options{Path: "/", MaxAge: 60, Secure: true}
+	derived := original.WithDefaultOptions(secure)
+	secure.Path = "/mutated"
+	assert.Equal(t, &sessions.Options{Path: "/", MaxAge: 60, Secure: true}, derived.defaultOptions)
+	assert.False(t, original.defaultOptions.Secure)
+	assert.Same(t, opts, original.defaultOptions)
+
+	defaulted := original.WithDefaultOptions(nil)
+	assert.Equal(t, &sessions.Options{Path: "/", MaxAge: 3600}, defaulted.defaultOptions)
+}
@@ -1,10 +1,53 @@
 package sessions_mongo
 
 import (
+	"errors"
 	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
 	"time"
 )
 
+//ErrSessionNotFound is returned when an operation expects a stored session that does not exist
+var ErrSessionNotFound = errors.New("session not found")
+
+//ErrSessionExpired is returned by ValidateCookie when the session exists but has expired
+var ErrSessionExpired = errors.New("session expired")
+
+//ErrSessionBindingMismatch is returned by ValidateBound when a session is used from a
+//client other than the one it was bound to
+var ErrSessionBindingMismatch = errors.New("session is bound to another client")
+
+//ErrEmptyIDPrefix is returned by DeleteByPrefix when called without a prefix
+var ErrEmptyIDPrefix = errors.New("ID prefix must not be empty")
+
+//ErrNoSessionID is returned by DecodeID when the request carries no session ID
+var ErrNoSessionID = errors.New("request carries no session ID")
+
+//ErrCookieNameMismatch is wrapped by the *InvalidCookieErr New and DecodeID return when
+//the cookie of a session holds the signed ID of another session of the request, e.g. a
+//cookie copied from one session name to another, rather than a tampered value
+var ErrCookieNameMismatch = errors.New("cookie was issued for another session name")
+
+//ErrTTLIndexConflict is returned when a TTL index already exists on last_modified with an
+//expireAfterSeconds different from Options.TTLOptions.TTL.  Rebuild the index with
+//RebuildTTLIndex or configure a matching TTL.
+var ErrTTLIndexConflict = errors.New("existing TTL index conflicts with configured TTL")
+
+//ErrNoUserIDField is returned by queries about users when Options.UserIDField is not configured
+var ErrNoUserIDField = errors.New("no UserIDField configured")
+
+//ErrNilCollection is returned when the store is given a nil collection, or one that isn't
+//backed by a client, e.g. a zero mongo.Collection
+var ErrNilCollection = errors.New("collection is nil or not backed by a client")
+
+//ErrConcurrentUpdate is returned by UpdateValues when the session kept being modified
+//while its values were being updated
+var ErrConcurrentUpdate = errors.New("session was modified concurrently")
+
+//errSessionReset signals that load discarded an undecodable session and reset it to a fresh one
+var errSessionReset = errors.New("undecodable session was reset")
+
 //InvalidTTLErr is an error regarding the Options.TTLOptions.TTL passed in to
 //NewMongoDBStore
 type InvalidTTLErr struct {
@@ -18,3 +61,307 @@ func NewInvalidTTLErr(ttl time.Duration) *InvalidTTLErr {
 func (e *InvalidTTLErr) Error() string {
 	return fmt.Sprintf("ttl cannot be 0 or fewer seconds; supplies ttl: %d", int(e.invalidTTL.Seconds()))
 }
+
+//DuplicateSessionIDErr is returned when inserting a new session whose ID is already stored
+type DuplicateSessionIDErr struct {
+	sessionID string
+	err       error
+}
+
+func NewDuplicateSessionIDErr(sessionID string, err error) *DuplicateSessionIDErr {
+	return &DuplicateSessionIDErr{sessionID: sessionID, err: err}
+}
+
+func (e *DuplicateSessionIDErr) Error() string {
+	return fmt.Sprintf("session ID already exists: %s", e.sessionID)
+}
+
+func (e *DuplicateSessionIDErr) Unwrap() error {
+	return e.err
+}
+
+func isDuplicateKeyError(err error) bool {
+	var we mongo.WriteException
+	if !errors.As(err, &we) {
+		return false
+	}
+
+	for _, writeErr := range we.WriteErrors {
+		switch writeErr.Code {
+		case 11000, 11001, 12582:
+			return true
+		}
+	}
+
+	return false
+}
+
+//InvalidSessionIDErr is returned when a supplied session ID is not a valid hex encoded ObjectID
+type InvalidSessionIDErr struct {
+	sessionID string
+	err       error
+}
+
+func NewInvalidSessionIDErr(sessionID string, err error) *InvalidSessionIDErr {
+	return &InvalidSessionIDErr{sessionID: sessionID, err: err}
+}
+
+func (e *InvalidSessionIDErr) Error() string {
+	return fmt.Sprintf("session ID must be a hex encoded ObjectID; supplied session ID: %q", e.sessionID)
+}
+
+func (e *InvalidSessionIDErr) Unwrap() error {
+	return e.err
+}
+
+//NonStringValueKeyErr is returned when a session with a non-string key in its Values is
+//saved using SerializationBSON
+type NonStringValueKeyErr struct {
+	key interface{}
+}
+
+func NewNonStringValueKeyErr(key interface{}) *NonStringValueKeyErr {
+	return &NonStringValueKeyErr{key: key}
+}
+
+func (e *NonStringValueKeyErr) Error() string {
+	return fmt.Sprintf("session value keys must be strings when stored as BSON; supplied key: %v (%T)", e.key, e.key)
+}
+
+//SaveAllErr is returned by SaveAll when one or more sessions could not be saved
+type SaveAllErr struct {
+	failures map[string]error
+}
+
+func NewSaveAllErr(failures map[string]error) *SaveAllErr {
+	return &SaveAllErr{failures: failures}
+}
+
+func (e *SaveAllErr) Error() string {
+	return fmt.Sprintf("failed to save %d session(s)", len(e.failures))
+}
+
+//Failures returns the error encountered for each session that failed to save, keyed by session ID
+func (e *SaveAllErr) Failures() map[string]error {
+	return e.failures
+}
+
+//ReservedFieldErr is returned when an option attempts to supply a document field managed by the store
+type ReservedFieldErr struct {
+	field string
+}
+
+func NewReservedFieldErr(field string) *ReservedFieldErr {
+	return &ReservedFieldErr{field: field}
+}
+
+func (e *ReservedFieldErr) Error() string {
+	return fmt.Sprintf("field is reserved for use by the store; supplied field: %s", e.field)
+}
+
+//InvalidLegacyDocumentErr is returned when a legacy session document does not hold its
+//values as a JSON string in the configured data field
+type InvalidLegacyDocumentErr struct {
+	sessionID string
+	field     string
+}
+
+func NewInvalidLegacyDocumentErr(sessionID string, field string) *InvalidLegacyDocumentErr {
+	return &InvalidLegacyDocumentErr{sessionID: sessionID, field: field}
+}
+
+func (e *InvalidLegacyDocumentErr) Error() string {
+	return fmt.Sprintf("legacy session %s has no JSON string in field %q", e.sessionID, e.field)
+}
+
+//InvalidIntervalErr is returned when an option requiring a positive interval is given
+//a zero or negative one
+type InvalidIntervalErr struct {
+	option   string
+	interval time.Duration
+}
+
+func NewInvalidIntervalErr(option string, interval time.Duration) *InvalidIntervalErr {
+	return &InvalidIntervalErr{option: option, interval: interval}
+}
+
+func (e *InvalidIntervalErr) Error() string {
+	return fmt.Sprintf("%s must be positive; supplied interval: %s", e.option, e.interval)
+}
+
+//InvalidImportErr is returned when the payload supplied to ImportSession is malformed
+type InvalidImportErr struct {
+	reason string
+}
+
+func NewInvalidImportErr(reason string) *InvalidImportErr {
+	return &InvalidImportErr{reason: reason}
+}
+
+func (e *InvalidImportErr) Error() string {
+	return fmt.Sprintf("invalid session import: %s", e.reason)
+}
+
+//IncompatibleOptionsErr is returned when options that cannot be used together are supplied
+type IncompatibleOptionsErr struct {
+	reason string
+}
+
+func NewIncompatibleOptionsErr(reason string) *IncompatibleOptionsErr {
+	return &IncompatibleOptionsErr{reason: reason}
+}
+
+func (e *IncompatibleOptionsErr) Error() string {
+	return fmt.Sprintf("incompatible options: %s", e.reason)
+}
+
+func isIndexConflictError(err error) bool {
+	var ce mongo.CommandError
+	if !errors.As(err, &ce) {
+		return false
+	}
+
+	return ce.Code == 85 || ce.Name == "IndexOptionsConflict"
+}
+
+//isIndexNotFoundError reports whether err is due to a missing index or a missing collection
+func isIndexNotFoundError(err error) bool {
+	var ce mongo.CommandError
+	if !errors.As(err, &ce) {
+		return false
+	}
+
+	return ce.Code == 27 || ce.Code == 26
+}
+
+//InvalidCappedSizeErr is returned when CappedOptions are enabled without a positive SizeBytes
+type InvalidCappedSizeErr struct {
+	size int64
+}
+
+func NewInvalidCappedSizeErr(size int64) *InvalidCappedSizeErr {
+	return &InvalidCappedSizeErr{size: size}
+}
+
+func (e *InvalidCappedSizeErr) Error() string {
+	return fmt.Sprintf("capped collection size must be positive; supplied size: %d", e.size)
+}
+
+//InvalidIDPrefixErr is returned when Options.IDPrefix or the prefix passed to
+//DeleteByPrefix contains the separator of prefixed _ids
+type InvalidIDPrefixErr struct {
+	prefix string
+}
+
+func NewInvalidIDPrefixErr(prefix string) *InvalidIDPrefixErr {
+	return &InvalidIDPrefixErr{prefix: prefix}
+}
+
+func (e *InvalidIDPrefixErr) Error() string {
+	return fmt.Sprintf("ID prefix must not contain %q; supplied prefix: %s", idPrefixSeparator, e.prefix)
+}
+
+//UnregisteredTypeErr is returned by Save when gob cannot encode sess.Values because the
+//type of a value was never registered, see Options.RegisterTypes
+type UnregisteredTypeErr struct {
+	typeName string
+	err      error
+}
+
+func NewUnregisteredTypeErr(typeName string, err error) *UnregisteredTypeErr {
+	return &UnregisteredTypeErr{typeName: typeName, err: err}
+}
+
+func (e *UnregisteredTypeErr) Error() string {
+	return fmt.Sprintf("session value of type %s is not registered with gob; add it to "+
+		"Options.RegisterTypes or call gob.Register", e.typeName)
+}
+
+func (e *UnregisteredTypeErr) Unwrap() error {
+	return e.err
+}
+
+//TypeName is the name gob reported for the unregistered type, e.g. "main.Profile"
+func (e *UnregisteredTypeErr) TypeName() string {
+	return e.typeName
+}
+
+//unregisteredTypeMessage prefixes the type name in gob's error about an unregistered type,
+//which securecookie embeds in its own errors
+const unregisteredTypeMessage = "type not registered for interface: "
+
+//asUnregisteredTypeErr returns an *UnregisteredTypeErr wrapping err if err is gob
+//failing on an unregistered type, and err otherwise
+func asUnregisteredTypeErr(err error) error {
+	msg := err.Error()
+	i := strings.Index(msg, unregisteredTypeMessage)
+	if i < 0 {
+		return err
+	}
+
+	typeName := msg[i+len(unregisteredTypeMessage):]
+	if end := strings.IndexByte(typeName, ' '); end >= 0 {
+		typeName = typeName[:end]
+	}
+
+	return NewUnregisteredTypeErr(typeName, err)
+}
+
+//isUnacknowledgedWrite reports whether err is the driver reporting a write sent with an
+//unacknowledged write concern, such as w:0, whose outcome is unknown rather than failed
+func isUnacknowledgedWrite(err error) bool {
+	return errors.Is(err, mongo.ErrUnacknowledgedWrite)
+}
+
+//isUnauthorizedError reports whether err is MongoDB refusing a command the user lacks
+//the privilege for
+func isUnauthorizedError(err error) bool {
+	var ce mongo.CommandError
+	if !errors.As(err, &ce) {
+		return false
+	}
+
+	return ce.Code == 13 || ce.Name == "Unauthorized"
+}
+
+func isNamespaceExistsError(err error) bool {
+	var ce mongo.CommandError
+	if !errors.As(err, &ce) {
+		return false
+	}
+
+	return ce.Code == 48 || ce.Name == "NamespaceExists"
+}
+
+//InvalidSessionNameErr is returned when a session name cannot be used as the path of a
+//sub-document in LayoutSubdocument
+type InvalidSessionNameErr struct {
+	name string
+}
+
+func NewInvalidSessionNameErr(name string) *InvalidSessionNameErr {
+	return &InvalidSessionNameErr{name: name}
+}
+
+func (e *InvalidSessionNameErr) Error() string {
+	return fmt.Sprintf("invalid session name %q: names may not be empty, contain '.' or start with '$'", e.name)
+}
+
+//InvalidCookieErr is returned when a cookie value cannot be decoded into a session ID,
+//e.g. because it was tampered with or signed with other codecs
+type InvalidCookieErr struct {
+	name string
+	err  error
+}
+
+func NewInvalidCookieErr(name string, err error) *InvalidCookieErr {
+	return &InvalidCookieErr{name: name, err: err}
+}
+
+func (e *InvalidCookieErr) Error() string {
+	return fmt.Sprintf("invalid cookie for session %q: %v", e.name, e.err)
+}
+
+func (e *InvalidCookieErr) Unwrap() error {
+	return e.err
+}
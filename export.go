@@ -0,0 +1,171 @@
+package sessions_mongo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+//ExportedSession is the portable JSON representation of a stored session produced by
+//ExportSession
+type ExportedSession struct {
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	CreatedAt    time.Time              `json:"created_at"`
+	LastModified time.Time              `json:"last_modified"`
+	Values       map[string]interface{} `json:"values"`
+}
+
+//Redactor replaces the value stored under key before it is exported
+type Redactor func(key string, value interface{}) interface{}
+
+//ExportSession loads the session stored under sessionID, decodes its values and returns
+//them along with the session metadata as JSON.  The session name is required because
+//it is bound into the encoded values by the codecs.  An optional redactor can mask
+//values before they are marshaled.
+//
+//Values are converted with encoding/json: keys that aren't strings are formatted with
+//fmt.Sprint, nested map[interface{}]interface{} values are converted recursively, struct
+//values only export their exported fields, and values json cannot represent (channels,
+//functions, ...) cause an error.
+func (store *MongoDBStore) ExportSession(ctx context.Context, name, sessionID string, redactor ...Redactor) ([]byte, error) {
+	s, sess, err := store.loadStoredSession(ctx, name, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := ExportedSession{
+		ID:           sessionID,
+		Name:         name,
+		CreatedAt:    s.CreatedAt,
+		LastModified: s.LastModified,
+		Values:       make(map[string]interface{}, len(sess.Values)),
+	}
+	for k, v := range sess.Values {
+		key := fmt.Sprint(k)
+		v = jsonFriendly(v)
+		for _, redact := range redactor {
+			v = redact(key, v)
+		}
+		exported.Values[key] = v
+	}
+
+	return json.Marshal(exported)
+}
+
+//loadStoredSession loads the session stored under sessionID and decodes its values as
+//a session called name, returning ErrSessionNotFound if it doesn't exist
+func (store *MongoDBStore) loadStoredSession(ctx context.Context, name, sessionID string) (session, *sessions.Session, error) {
+	oid, err := parseSessionID(sessionID)
+	if err != nil {
+		return session{}, nil, err
+	}
+
+	s, err := store.findNamedSession(ctx, oid, name)
+	if err == mongo.ErrNoDocuments {
+		return session{}, nil, ErrSessionNotFound
+	}
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to load stored session",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return session{}, nil, err
+	}
+
+	sess := sessions.NewSession(store, name)
+	sess.ID = sessionID
+	if err = store.decodeValues(sess, s); err != nil {
+		return session{}, nil, err
+	}
+
+	return s, sess, nil
+}
+
+//jsonFriendly converts the map types gob commonly produces, which encoding/json
+//cannot marshal, into map[string]interface{}
+func jsonFriendly(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for k, nested := range typed {
+			converted[fmt.Sprint(k)] = jsonFriendly(nested)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(typed))
+		for i, nested := range typed {
+			converted[i] = jsonFriendly(nested)
+		}
+		return converted
+	}
+
+	return v
+}
+
+//registerJSONTypes registers with gob the types encoding/json decodes nested objects and
+//arrays to, so sessions restored by ImportSession can be saved and loaded with the default
+//codecs.  A conflict means the types are already registered under another name, which
+//serves as well.
+func registerJSONTypes() {
+	_ = registerTypes([]interface{}{map[string]interface{}{}, []interface{}{}})
+}
+
+//ImportSession recreates a session from the output of ExportSession, encoding its values
+//with this store's codecs, and returns the session's ID.  The exported ID is kept when
+//present, so importing overwrites an existing session with that ID; otherwise a new ID
+//is assigned.  Malformed payloads are rejected with an *InvalidImportErr.  Values are
+//restored as the types encoding/json decodes them to, e.g. numbers as float64 and nested
+//objects and arrays as map[string]interface{} and []interface{}, which NewMongoDBStore
+//registers with gob.  ImportSession is not supported with LayoutSubdocument.
+func (store *MongoDBStore) ImportSession(ctx context.Context, data []byte) (string, error) {
+	if store.storeOptions.Layout == LayoutSubdocument {
+		return "", NewIncompatibleOptionsErr("ImportSession is not supported with LayoutSubdocument")
+	}
+
+	var exported ExportedSession
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&exported); err != nil {
+		return "", NewInvalidImportErr(err.Error())
+	}
+
+	if exported.Name == "" {
+		return "", NewInvalidImportErr("missing session name")
+	}
+	if exported.Values == nil {
+		return "", NewInvalidImportErr("missing session values")
+	}
+	if exported.ID == "" {
+		exported.ID = primitive.NewObjectID().Hex()
+	} else if !isValidSessionID(exported.ID) {
+		return "", NewInvalidImportErr(fmt.Sprintf("invalid session ID %q", exported.ID))
+	}
+
+	sess := sessions.NewSession(store, exported.Name)
+	sess.ID = exported.ID
+	for k, v := range exported.Values {
+		sess.Values[k] = v
+	}
+
+	s, err := store.toDocument(sess)
+	if err != nil {
+		return "", err
+	}
+	if !exported.CreatedAt.IsZero() {
+		s.CreatedAt = exported.CreatedAt.UTC()
+	}
+
+	if err = store.saveSession(ctx, s); err != nil {
+		return "", err
+	}
+
+	return exported.ID, nil
+}
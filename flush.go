@@ -0,0 +1,33 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+//Flush is a durability checkpoint for stores using a relaxed WriteConcern such as w:0.
+//It performs a no-op update with a journaled majority write concern, which the server
+//only acknowledges once every write it applied before the update is journaled and
+//replicated to a majority of the replica set.  Unacknowledged writes still in flight on
+//another connection may not have reached the server yet and are not covered, nor are
+//writes the server rejected, since w:0 never reports errors.
+func (store *MongoDBStore) Flush(ctx context.Context) error {
+	wc := writeconcern.New(writeconcern.WMajority(), writeconcern.J(true))
+	collection, err := store.collection.Clone(options.Collection().SetWriteConcern(wc))
+	if err != nil {
+		return err
+	}
+
+	//a fresh ObjectID matches no session, so nothing is written
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": primitive.NewObjectID()}, bson.M{"$set": bson.M{"flushed": true}})
+	if err != nil {
+		_ = level.Error(store.logger).Log("message", "failed to flush writes", "error", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"sync"
+)
+
+//healthState is the connection health last observed by the health monitor.  It lives
+//behind a pointer so copies of the store share it.
+type healthState struct {
+	mu        sync.Mutex
+	unhealthy bool
+}
+
+//Healthy reports whether the last check of the health monitor reached MongoDB.  It is
+//always true without Options.HealthMonitor, and before the first check, since
+//NewMongoDBStore verified the connection.
+func (store *MongoDBStore) Healthy() bool {
+	if store.health == nil {
+		return true
+	}
+
+	store.health.mu.Lock()
+	defer store.health.mu.Unlock()
+	return !store.health.unhealthy
+}
+
+//checkHealth is run periodically by the health monitor when Options.HealthMonitor is
+//enabled.  It pings the deployment, only accepting a primary with
+//Options.RequirePrimaryOnConnect, and logs transitions between healthy and unhealthy.
+func (store *MongoDBStore) checkHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), store.storeOptions.HealthMonitor.Interval)
+	defer cancel()
+
+	err := store.collection.Database().Client().Ping(ctx, pingReadPref(store.storeOptions.RequirePrimaryOnConnect))
+
+	store.health.mu.Lock()
+	wasUnhealthy := store.health.unhealthy
+	store.health.unhealthy = err != nil
+	store.health.mu.Unlock()
+
+	switch {
+	case err != nil && !wasUnhealthy:
+		_ = level.Error(store.logger).Log(
+			"message", "lost connection to MongoDB",
+			"error", err,
+		)
+	case err == nil && wasUnhealthy:
+		_ = level.Info(store.logger).Log("message", "reconnected to MongoDB")
+	}
+}
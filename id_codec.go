@@ -0,0 +1,35 @@
+package sessions_mongo
+
+import (
+	"github.com/gorilla/securecookie"
+)
+
+//IDCodec encodes a session ID into the value transported to the client, and decodes it
+//back.  Implementations can replace securecookie with e.g. signed JWTs or opaque tokens.
+type IDCodec interface {
+	Encode(name, id string) (string, error)
+	Decode(name, encoded string) (string, error)
+}
+
+type secureCookieIDCodec struct {
+	codecs []securecookie.Codec
+}
+
+//NewSecureCookieIDCodec returns the default IDCodec, which encodes session IDs with
+//securecookie.EncodeMulti using codecs
+func NewSecureCookieIDCodec(codecs ...securecookie.Codec) IDCodec {
+	return secureCookieIDCodec{codecs: codecs}
+}
+
+func (c secureCookieIDCodec) Encode(name, id string) (string, error) {
+	return securecookie.EncodeMulti(name, id, c.codecs...)
+}
+
+func (c secureCookieIDCodec) Decode(name, encoded string) (string, error) {
+	var id string
+	if err := securecookie.DecodeMulti(name, encoded, &id, c.codecs...); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
@@ -1,14 +1,97 @@
 package sessions_mongo
 
 import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync/atomic"
 	"time"
 )
 
+const ttlIndexName = "last_modified_1"
+
+//ttlIndexState records whether the TTL index on the store's collection was ensured.  It
+//lives behind a pointer and is accessed atomically, so RebuildTTLIndex can update it
+//while other goroutines call TTLIndexEnsured on the store or on stores derived from it.
+type ttlIndexState struct {
+	ensured int32
+}
+
+func newTTLIndexState(ensured bool) *ttlIndexState {
+	state := &ttlIndexState{}
+	state.setEnsured(ensured)
+	return state
+}
+
+func (state *ttlIndexState) isEnsured() bool {
+	return state != nil && atomic.LoadInt32(&state.ensured) == 1
+}
+
+func (state *ttlIndexState) setEnsured(ensured bool) {
+	var value int32
+	if ensured {
+		value = 1
+	}
+	atomic.StoreInt32(&state.ensured, value)
+}
+
+//RebuildTTLIndex drops the TTL index on last_modified, if it exists, and recreates it
+//with the store's TTL.  Use it to resolve ErrTTLIndexConflict after changing the TTL.
+//Sessions are not expired by the index between the drop and the creation.
+func (store *MongoDBStore) RebuildTTLIndex(ctx context.Context) error {
+	_, err := store.collection.Indexes().DropOne(ctx, ttlIndexName)
+	if err != nil && !isIndexNotFoundError(err) {
+		_ = level.Error(store.logger).Log("message", "failed to drop TTL index", "error", err)
+		return err
+	}
+
+	if err = ensureTTLIndex(ctx, store.collection.Indexes(), store.ttl); err != nil {
+		_ = level.Error(store.logger).Log("message", "failed to recreate TTL index", "error", err)
+		store.ttlIndex.setEnsured(false)
+		return err
+	}
+	store.ttlIndex.setEnsured(true)
+
+	return nil
+}
+
+//existingIndexTTL returns the expireAfterSeconds of the TTL index on last_modified of c,
+//reporting false if there is none
+func existingIndexTTL(ctx context.Context, c collection) (time.Duration, bool, error) {
+	cursor, err := c.Indexes().List(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	//closing with ctx would leave the server cursor open once ctx is cancelled
+	defer cursor.Close(context.Background())
+
+	var indexes []struct {
+		Key                bson.D `bson:"key"`
+		ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+	}
+	if err = cursor.All(ctx, &indexes); err != nil {
+		return 0, false, err
+	}
+
+	for _, index := range indexes {
+		if len(index.Key) != 1 || index.Key[0].Key != "last_modified" || index.ExpireAfterSeconds == nil {
+			continue
+		}
+		if *index.ExpireAfterSeconds <= 0 {
+			return 0, false, nil
+		}
+		return time.Duration(*index.ExpireAfterSeconds) * time.Second, true, nil
+	}
+
+	return 0, false, nil
+}
+
 func makeTTLIndexModel(ttl time.Duration) mongo.IndexModel {
-	idxOpts := options.Index().SetExpireAfterSeconds(int32(ttl.Seconds()))
+	idxOpts := options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())).SetName(ttlIndexName)
 	return mongo.IndexModel{
 		Keys: bson.D{
 			{
@@ -19,3 +102,23 @@ func makeTTLIndexModel(ttl time.Duration) mongo.IndexModel {
 		Options: idxOpts,
 	}
 }
+
+//ensureAdditionalIndexes creates each of indexes on collection, stopping at the first failure
+func ensureAdditionalIndexes(
+	ctx context.Context,
+	collection *mongo.Collection,
+	indexes []mongo.IndexModel,
+	logger log.Logger,
+) error {
+	idxOpts := options.CreateIndexes().SetMaxTime(15 * time.Second)
+	for _, index := range indexes {
+		name, err := collection.Indexes().CreateOne(ctx, index, idxOpts)
+		if err != nil {
+			_ = level.Error(logger).Log("message", "failed to ensure index", "keys", fmt.Sprintf("%v", index.Keys), "error", err)
+			return err
+		}
+		_ = level.Info(logger).Log("message", "ensured index", "index", name)
+	}
+
+	return nil
+}
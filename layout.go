@@ -0,0 +1,216 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+)
+
+//Layout determines how sessions are arranged in the collection
+type Layout int
+
+const (
+	//LayoutDocument stores every session in a document of its own
+	LayoutDocument Layout = iota
+	//LayoutSubdocument stores all sessions sharing an ID, e.g. the ObjectID of a user
+	//assigned through NewWithID, in a single document, each under `sessions.<name>`.
+	//Saving a session also refreshes the top-level last_modified of the document, so
+	//the TTL index and DeleteExpiredSessions remove a document once none of its sessions
+	//was saved within the TTL; TTLOptions.EnforceOnRead expires sessions individually.
+	//Session names may not contain '.' or start with '$'.
+	//
+	//Clearing a session through Save removes only its sub-document, while Delete removes
+	//the document with every session in it.  The remaining methods that read or write
+	//stored sessions by ID alone, such as LoadAndTouch, SaveAll, MigrateFormat,
+	//ImportSession and the metadata methods, operate on LayoutDocument documents and are
+	//not supported.  SkipUnchangedWrites, WriteModeStrict, Cache, CappedOptions,
+	//SoftDelete, Binding, TTLOptions.RefreshOnLoad and LegacyOptions are incompatible with
+	//this layout.
+	LayoutSubdocument
+)
+
+//validateSubdocumentLayout rejects options that can't be combined with LayoutSubdocument
+func (o Options) validateSubdocumentLayout() error {
+	switch {
+	case o.SkipUnchangedWrites:
+		return NewIncompatibleOptionsErr("SkipUnchangedWrites is not supported with LayoutSubdocument")
+	case o.WriteMode == WriteModeStrict:
+		return NewIncompatibleOptionsErr("WriteModeStrict is not supported with LayoutSubdocument")
+	case o.Cache != nil:
+		return NewIncompatibleOptionsErr("Cache is not supported with LayoutSubdocument")
+	case o.CappedOptions.Enabled:
+		return NewIncompatibleOptionsErr("CappedOptions are not supported with LayoutSubdocument")
+	case o.Binding.Enabled:
+		return NewIncompatibleOptionsErr("Binding is not supported with LayoutSubdocument")
+	case o.SoftDelete.Enabled:
+		return NewIncompatibleOptionsErr("SoftDelete is not supported with LayoutSubdocument")
+	case o.TTLOptions.RefreshOnLoad:
+		return NewIncompatibleOptionsErr("TTLOptions.RefreshOnLoad is not supported with LayoutSubdocument")
+	case o.LegacyOptions.Enabled:
+		return NewIncompatibleOptionsErr("LegacyOptions are not supported with LayoutSubdocument")
+	}
+
+	return nil
+}
+
+//subdocumentPath returns the path of the sub-document holding the session named name
+func subdocumentPath(name string) (string, error) {
+	if name == "" || strings.Contains(name, ".") || strings.HasPrefix(name, "$") {
+		return "", NewInvalidSessionNameErr(name)
+	}
+
+	return "sessions." + name, nil
+}
+
+//findNamedSession loads the stored session with the given ID and name in the store's layout
+func (store *MongoDBStore) findNamedSession(ctx context.Context, oid primitive.ObjectID, name string) (session, error) {
+	if store.storeOptions.Layout != LayoutSubdocument {
+		return store.findSession(ctx, oid, name)
+	}
+
+	path, err := subdocumentPath(name)
+	if err != nil {
+		return session{}, err
+	}
+
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return session{}, err
+	}
+
+	var doc struct {
+		Sessions map[string]session `bson:"sessions"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{path: 1})
+	err = store.withRetry(ctx, func() error {
+		return c.FindOne(ctx, store.idFilter(oid), opts).Decode(&doc)
+	})
+	if err != nil {
+		return session{}, err
+	}
+
+	s, ok := doc.Sessions[name]
+	if !ok {
+		return session{}, mongo.ErrNoDocuments
+	}
+	s.ID = store.documentID(oid)
+
+	return s, nil
+}
+
+//saveSubdocument upserts sess into the sub-document named after it
+func (store *MongoDBStore) saveSubdocument(ctx context.Context, sess *sessions.Session) error {
+	logger := store.contextLogger(ctx)
+	path, err := subdocumentPath(sess.Name())
+	if err != nil {
+		return err
+	}
+
+	s, err := store.toDocument(sess)
+	if err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to transform session",
+			"error", err,
+		)
+		return err
+	}
+
+	prefix := path + "."
+	set := bson.M{
+		"last_modified":          s.LastModified,
+		prefix + "data":          s.Data,
+		prefix + "last_modified": s.LastModified,
+		prefix + "expires_at":    s.ExpiresAt,
+		prefix + "values_format": s.Format,
+	}
+	scoped := store.sessionFields(sess.ID)
+	for k, v := range s.Extra {
+		if _, ok := scoped[k]; ok {
+			set[k] = v
+		} else {
+			set[prefix+k] = v
+		}
+	}
+	update := bson.M{
+		"$set": set,
+		//$min only sets created_at when the sub-document doesn't have one yet
+		"$min": bson.M{prefix + "created_at": s.CreatedAt},
+	}
+	if len(s.Values) > 0 {
+		set[prefix+"values"] = s.Values
+	} else {
+		update["$unset"] = bson.M{prefix + "values": ""}
+	}
+
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return err
+	}
+
+	var res *mongo.UpdateResult
+	opts := options.Update().SetUpsert(true)
+	err = store.withRetry(ctx, func() error {
+		var err error
+		res, err = c.UpdateOne(ctx, store.idFilter(s.ObjectID()), update, opts)
+		return err
+	})
+	if isUnacknowledgedWrite(err) {
+		return nil
+	}
+	if err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to save session in database",
+			"session_id", sess.ID,
+			"error", err,
+		)
+		return err
+	}
+	recordSaveResult(ctx, upsertResult(res))
+
+	return nil
+}
+
+//deleteNamed removes the stored session with the given ID and name in the store's
+//layout and reports whether it existed
+func (store *MongoDBStore) deleteNamed(ctx context.Context, sessionID, name string) (bool, error) {
+	if store.storeOptions.Layout != LayoutSubdocument {
+		return store.delete(ctx, sessionID, name)
+	}
+
+	oid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return false, err
+	}
+	path, err := subdocumentPath(name)
+	if err != nil {
+		return false, err
+	}
+
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var res *mongo.UpdateResult
+	err = store.withRetry(ctx, func() error {
+		res, err = c.UpdateOne(ctx, store.idFilter(oid), bson.M{"$unset": bson.M{path: ""}})
+		return err
+	})
+	if isUnacknowledgedWrite(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if res.ModifiedCount == 0 {
+		return false, nil
+	}
+	store.invalidated(sessionID)
+
+	return true, nil
+}
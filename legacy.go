@@ -0,0 +1,67 @@
+package sessions_mongo
+
+import (
+	"encoding/json"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func (store *MongoDBStore) isLegacyDocument(s session) bool {
+	return store.storeOptions.LegacyOptions.Enabled && s.LastModified.IsZero() && len(s.Values) == 0
+}
+
+//excludeLegacy makes filter skip the documents isLegacyDocument recognizes, which would
+//no longer be read as legacy once a touch set their last_modified
+func (store *MongoDBStore) excludeLegacy(filter bson.M) bson.M {
+	if store.storeOptions.LegacyOptions.Enabled {
+		filter["$nor"] = bson.A{bson.M{"last_modified": nil, "values": nil}}
+	}
+	return filter
+}
+
+func (store *MongoDBStore) legacyDataField() string {
+	if store.storeOptions.LegacyOptions.DataField == "" {
+		return "data"
+	}
+
+	return store.storeOptions.LegacyOptions.DataField
+}
+
+//legacyFields lists the legacy fields to remove when a session is rewritten
+func (store *MongoDBStore) legacyFields() []string {
+	legacy := store.storeOptions.LegacyOptions
+	if !legacy.Enabled {
+		return nil
+	}
+
+	fields := append([]string(nil), legacy.RemovedFields...)
+	if dataField := store.legacyDataField(); dataField != "data" {
+		fields = append(fields, dataField)
+	}
+
+	return fields
+}
+
+func (store *MongoDBStore) decodeLegacyValues(sess *sessions.Session, s session) error {
+	dataField := store.legacyDataField()
+	data := s.Data
+	if dataField != "data" {
+		var ok bool
+		if data, ok = s.Extra[dataField].(string); !ok {
+			return NewInvalidLegacyDocumentErr(sess.ID, dataField)
+		}
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &decoded); err != nil {
+		return err
+	}
+
+	values := make(map[interface{}]interface{}, len(decoded))
+	for k, v := range decoded {
+		values[k] = v
+	}
+	sess.Values = values
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"sync"
+)
+
+//swappableLogger is the logger of a store, delegating to a logger that SetLogger can
+//replace while the store is in use
+type swappableLogger struct {
+	mu     sync.RWMutex
+	logger log.Logger
+}
+
+func newSwappableLogger(logger log.Logger) *swappableLogger {
+	return &swappableLogger{logger: logger}
+}
+
+func (l *swappableLogger) Log(keyvals ...interface{}) error {
+	l.mu.RLock()
+	logger := l.logger
+	l.mu.RUnlock()
+
+	return logger.Log(keyvals...)
+}
+
+func (l *swappableLogger) current() log.Logger {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.logger
+}
+
+func (l *swappableLogger) set(logger log.Logger) {
+	l.mu.Lock()
+	l.logger = logger
+	l.mu.Unlock()
+}
+
+//SetLogger replaces the logger of the store, e.g. to temporarily log more verbosely while
+//debugging, and is safe to call while the store is in use.  A nil logger disables logging
+//like passing nil to NewMongoDBStore does; any other logger enables it.  Stores derived
+//through the With* methods keep the logger they were derived with.
+func (store *MongoDBStore) SetLogger(logger log.Logger) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	if swappable, ok := store.logger.(*swappableLogger); ok {
+		swappable.set(logger)
+		return
+	}
+	store.logger = newSwappableLogger(logger)
+}
+
+//cloneLogger gives a derived store its own logger, so SetLogger on either store doesn't
+//affect the other
+func (store *MongoDBStore) cloneLogger() log.Logger {
+	if swappable, ok := store.logger.(*swappableLogger); ok {
+		return newSwappableLogger(swappable.current())
+	}
+
+	return store.logger
+}
+
+//contextLogger returns the store's logger with the request ID extracted from ctx by
+//Options.RequestIDFromContext, if any
+func (store *MongoDBStore) contextLogger(ctx context.Context) log.Logger {
+	if store.storeOptions.RequestIDFromContext == nil {
+		return store.logger
+	}
+
+	requestID := store.storeOptions.RequestIDFromContext(ctx)
+	if requestID == "" {
+		return store.logger
+	}
+
+	return log.With(store.logger, "request_id", requestID)
+}
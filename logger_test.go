@@ -0,0 +1,64 @@
+package sessions_mongo
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+func TestMongoDBStore_SetLogger(t *testing.T) {
+	store := &MongoDBStore{logger: newSwappableLogger(log.NewNopLogger())}
+	derived := store.clone()
+
+	var buf bytes.Buffer
+	store.SetLogger(log.NewLogfmtLogger(&buf))
+	_ = derived.logger.Log("message", "derived")
+	_ = store.logger.Log("message", "verbose")
+	assert.Equal(t, "message=verbose\n", buf.String())
+
+	buf.Reset()
+	store.SetLogger(nil)
+	_ = store.logger.Log("message", "silenced")
+	assert.Empty(t, buf.String())
+}
+
+type requestIDKey struct{}
+
+func TestMongoDBStore_contextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	store := &MongoDBStore{
+		logger: log.NewLogfmtLogger(&buf),
+		storeOptions: Options{RequestIDFromContext: func(ctx context.Context) string {
+			requestID, _ := ctx.Value(requestIDKey{}).(string)
+			return requestID
+		}},
+	}
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+	_ = store.contextLogger(ctx).Log("message", "saved")
+	_ = store.contextLogger(context.Background()).Log("message", "loaded")
+	assert.Equal(t, "request_id=req-1 message=saved\nmessage=loaded\n", buf.String())
+}
+
+func TestMongoDBStore_withRetry_contextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	store := &MongoDBStore{
+		logger: log.NewLogfmtLogger(&buf),
+		storeOptions: Options{
+			RetryOptions: RetryOptions{MaxAttempts: 2},
+			RequestIDFromContext: func(ctx context.Context) string {
+				requestID, _ := ctx.Value(requestIDKey{}).(string)
+				return requestID
+			},
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+	_ = store.withRetry(ctx, func() error {
+		return mongo.CommandError{Code: 91, Name: "ShutdownInProgress"}
+	})
+	assert.Contains(t, buf.String(), "request_id=req-1 ")
+}
@@ -0,0 +1,244 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"time"
+)
+
+//DeleteExpiredSessions deletes every session past its expires_at, as well as sessions
+//stored without one that were not modified within the store's TTL, and returns the number
+//of sessions deleted.  It supplements the TTL index, whose monitor only runs periodically,
+//or replaces it where TTL indexes are unavailable.
+func (store *MongoDBStore) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	now := store.now().Add(-store.storeOptions.TTLOptions.ClockSkew)
+	filter := store.scopedFilter(bson.M{"$or": bson.A{
+		bson.M{"expires_at": bson.M{"$lt": now}},
+		bson.M{
+			"expires_at":    bson.M{"$exists": false},
+			"last_modified": bson.M{"$lt": now.Add(-store.ttl)},
+		},
+	}})
+
+	res, err := store.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to delete expired sessions",
+			"error", err,
+		)
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+//DeleteSessionsModifiedBefore deletes every session last modified before cutoff, whether
+//or not it has expired, and returns the number of sessions deleted, e.g. to log out
+//everyone who was active before a breach was contained.  With Options.SoftDelete the
+//sessions are turned into tombstones instead.  Sessions held by Options.Cache keep being
+//served until their cache entries expire.
+func (store *MongoDBStore) DeleteSessionsModifiedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	filter := store.scopedFilter(bson.M{"last_modified": bson.M{"$lt": cutoff}})
+
+	var deleted int64
+	var err error
+	if store.storeOptions.SoftDelete.Enabled {
+		var res *mongo.UpdateResult
+		res, err = store.collection.UpdateMany(ctx, filter, bson.M{
+			"$set":   bson.M{"deleted_at": store.now()},
+			"$unset": bson.M{"last_modified": "", "expires_at": "", "values_hash": ""},
+		})
+		if err == nil {
+			deleted = res.ModifiedCount
+		}
+	} else {
+		var res *mongo.DeleteResult
+		res, err = store.collection.DeleteMany(ctx, filter)
+		if err == nil {
+			deleted = res.DeletedCount
+		}
+	}
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to delete sessions modified before cutoff",
+			"cutoff", cutoff,
+			"error", err,
+		)
+		return 0, err
+	}
+
+	_ = level.Info(store.logger).Log(
+		"message", "deleted sessions modified before cutoff",
+		"cutoff", cutoff,
+		"deleted", deleted,
+	)
+	return deleted, nil
+}
+
+//DeleteByPrefix deletes every session saved with the given Options.IDPrefix, within
+//Options.BaseFilter, regardless of the store's own IDPrefix, e.g. for per-app cleanup of
+//a shared collection, and returns the number of sessions deleted.  Sessions held by
+//Options.Cache keep being served until their cache entries expire.
+func (store *MongoDBStore) DeleteByPrefix(ctx context.Context, prefix string) (int64, error) {
+	if prefix == "" {
+		return 0, ErrEmptyIDPrefix
+	}
+
+	if strings.Contains(prefix, idPrefixSeparator) {
+		return 0, NewInvalidIDPrefixErr(prefix)
+	}
+
+	filter := bson.M{}
+	for k, v := range store.storeOptions.BaseFilter {
+		filter[k] = v
+	}
+	filter["_id"] = idPrefixFilter(prefix)
+
+	res, err := store.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to delete sessions by prefix",
+			"prefix", prefix,
+			"error", err,
+		)
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+//RepairMissingTimestamps backfills last_modified on sessions stored without it, e.g. by
+//older versions or external tools, which the TTL index would otherwise never expire, and
+//returns the number of sessions repaired.  last_modified is set to created_at where
+//present, so old sessions expire promptly, and to now otherwise.  Tombstones kept by
+//Options.SoftDelete are left alone, as are, with LegacyOptions enabled, documents without
+//values: those are told apart as legacy by their missing last_modified.  It uses an update
+//pipeline, which requires MongoDB 4.2 or later.
+func (store *MongoDBStore) RepairMissingTimestamps(ctx context.Context) (int64, error) {
+	filter := store.scopedFilter(bson.M{
+		"last_modified": nil,
+		"deleted_at":    bson.M{"$exists": false},
+	})
+	if store.storeOptions.LegacyOptions.Enabled {
+		//see isLegacyDocument
+		filter["values"] = bson.M{"$ne": nil}
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"last_modified": bson.M{"$ifNull": bson.A{"$created_at", store.now()}},
+		}}},
+	}
+
+	res, err := store.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to repair sessions missing last_modified",
+			"error", err,
+		)
+		return 0, err
+	}
+
+	if res.ModifiedCount > 0 {
+		_ = level.Info(store.logger).Log(
+			"message", "repaired sessions missing last_modified",
+			"repaired", res.ModifiedCount,
+		)
+	}
+	return res.ModifiedCount, nil
+}
+
+//DeleteUntimestampedSessions deletes sessions stored without last_modified or expires_at,
+//e.g. by versions predating them, whose ObjectID was generated before cutoff, and returns
+//the number of sessions deleted.  Neither the TTL index nor DeleteExpiredSessions can
+//expire such sessions, so the creation time embedded in their _id stands in for their
+//last modification: a cutoff of now minus the TTL deletes those that would have expired
+//had they never been used again.  Tombstones kept by Options.SoftDelete are left alone.
+//RepairMissingTimestamps is the alternative keeping these sessions alive.
+func (store *MongoDBStore) DeleteUntimestampedSessions(ctx context.Context, cutoff time.Time) (int64, error) {
+	filter := store.scopedFilter(bson.M{
+		"_id":           bson.M{"$lt": store.documentID(primitive.NewObjectIDFromTimestamp(cutoff))},
+		"last_modified": nil,
+		"expires_at":    bson.M{"$exists": false},
+		"deleted_at":    bson.M{"$exists": false},
+	})
+
+	res, err := store.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to delete sessions missing last_modified",
+			"cutoff", cutoff,
+			"error", err,
+		)
+		return 0, err
+	}
+
+	if res.DeletedCount > 0 {
+		_ = level.Info(store.logger).Log(
+			"message", "deleted sessions missing last_modified",
+			"cutoff", cutoff,
+			"deleted", res.DeletedCount,
+		)
+	}
+	return res.DeletedCount, nil
+}
+
+//SweepResult counts the documents deleted by Sweep
+type SweepResult struct {
+	//Expired is the number of sessions deleted by DeleteExpiredSessions
+	Expired int64
+	//Tombstones is the number of Options.SoftDelete tombstones purged after RetainFor
+	Tombstones int64
+	//Untimestamped is the number of sessions deleted by DeleteUntimestampedSessions, if
+	//SweepOptions.Untimestamped is set
+	Untimestamped int64
+}
+
+//Sweep runs every cleanup the store is configured for in one go: DeleteExpiredSessions,
+//the purge of Options.SoftDelete tombstones kept for longer than RetainFor and, with
+//SweepOptions.Untimestamped, DeleteUntimestampedSessions with a cutoff of the TTL.  It is
+//what the background sweeper runs, and can be called from a scheduled job instead where
+//TTL indexes are unavailable.  It stops at the first failing step, returning the counts
+//of the steps that succeeded along with the error.
+func (store *MongoDBStore) Sweep(ctx context.Context) (SweepResult, error) {
+	var result SweepResult
+	var err error
+	if result.Expired, err = store.DeleteExpiredSessions(ctx); err != nil {
+		return result, err
+	}
+
+	if store.storeOptions.SoftDelete.Enabled {
+		if result.Tombstones, err = store.purgeTombstones(ctx); err != nil {
+			return result, err
+		}
+	}
+
+	if store.storeOptions.SweepOptions.Untimestamped {
+		cutoff := store.now().Add(-store.ttl)
+		if result.Untimestamped, err = store.DeleteUntimestampedSessions(ctx, cutoff); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+//sweep is run periodically by the background sweeper when Options.SweepOptions is enabled
+func (store *MongoDBStore) sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), store.storeOptions.SweepOptions.Interval)
+	defer cancel()
+
+	result, err := store.Sweep(ctx)
+	if err != nil {
+		return
+	}
+	_ = level.Debug(store.logger).Log(
+		"message", "swept expired sessions",
+		"deleted", result.Expired,
+		"tombstones", result.Tombstones,
+		"untimestamped", result.Untimestamped,
+	)
+}
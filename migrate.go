@@ -0,0 +1,140 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//MigrateFormat rewrites the values of stored sessions named name in the target
+//serialization format and returns the number of sessions rewritten.  Sessions are
+//decoded with this store's codecs, Registry and LegacyOptions regardless of the format
+//they were written in, and re-encoded with target.  After migrating, set
+//Options.Serialization to target so new saves use it as well.
+//
+//Every document written by the store records its format in values_format, and sessions
+//already marked with target are skipped, so MigrateFormat can be interrupted and run
+//again.  Sessions stored under another name are left untouched, allowing a collection
+//holding several names to be migrated one name at a time; sessions stored without a
+//name that fail to decode with name are logged and skipped.  Timestamps are
+//preserved, so migrating doesn't extend the life of a session, and a session saved
+//while it is being migrated keeps the concurrently saved values.  Cancelling ctx stops
+//the migration before the next session and returns ctx.Err() along with the number of
+//sessions migrated so far.  Tombstones kept by Options.SoftDelete are not migrated.
+//MigrateFormat is not supported with LayoutSubdocument or CappedOptions.
+func (store *MongoDBStore) MigrateFormat(ctx context.Context, name string, target SerializationFormat) (int64, error) {
+	if store.storeOptions.Layout == LayoutSubdocument {
+		return 0, NewIncompatibleOptionsErr("MigrateFormat is not supported with LayoutSubdocument")
+	}
+	if store.storeOptions.CappedOptions.Enabled {
+		return 0, NewIncompatibleOptionsErr("MigrateFormat is not supported with CappedOptions")
+	}
+
+	marker := target.marker()
+	//values stored as BSON decode under any name, so other names must not be rebound to name
+	filter := store.scopeToName(store.scopedFilter(bson.M{"values_format": bson.M{"$ne": marker}}), name)
+	filter = store.excludeTombstones(filter)
+	cursor, err := store.collection.Find(ctx, filter)
+	if err != nil {
+		_ = level.Error(store.logger).Log("message", "failed to find sessions to migrate", "error", err)
+		return 0, err
+	}
+	//closing with ctx would leave the server cursor open once ctx is cancelled
+	defer cursor.Close(context.Background())
+
+	encoder := store.clone()
+	encoder.storeOptions.Serialization = target
+
+	var migrated int64
+	for cursor.Next(ctx) {
+		//documents of a fetched batch are iterated without consulting ctx
+		if err = ctx.Err(); err != nil {
+			return migrated, err
+		}
+
+		var s session
+		if err = cursor.Decode(&s); err != nil {
+			return migrated, err
+		}
+
+		sess := sessions.NewSession(store, name)
+		sess.ID = s.ID.Hex()
+		if err = store.decodeValues(sess, s); err != nil {
+			_ = level.Warn(store.logger).Log(
+				"message", "skipping session that could not be decoded for migration",
+				"session_id", sess.ID,
+				"error", err,
+			)
+			continue
+		}
+
+		ok, err := encoder.rewriteFormat(ctx, sess, s)
+		if err != nil {
+			return migrated, err
+		}
+		if ok {
+			migrated++
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		_ = level.Error(store.logger).Log("message", "failed to iterate sessions to migrate", "error", err)
+		return migrated, err
+	}
+
+	return migrated, nil
+}
+
+//rewriteFormat replaces the stored document s with sess, encoded in the store's format,
+//unless s was modified in the meantime.  It reports whether the document was rewritten.
+func (store *MongoDBStore) rewriteFormat(ctx context.Context, sess *sessions.Session, s session) (bool, error) {
+	rewritten, err := store.toDocument(sess)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to encode session for migration",
+			"session_id", sess.ID,
+			"error", err,
+		)
+		return false, err
+	}
+	rewritten.ValuesHash = s.ValuesHash
+	if !s.LastModified.IsZero() {
+		rewritten.LastModified = s.LastModified
+		rewritten.CreatedAt = s.CreatedAt
+		rewritten.ExpiresAt = s.ExpiresAt
+		if rewritten.ExpiresAt.IsZero() {
+			rewritten.ExpiresAt = s.LastModified.Add(store.ttlFor(sess.Name()))
+		}
+	}
+
+	//rewriting a tombstone would bring the deleted session back
+	filter := store.excludeTombstones(store.namedFilter(s.ObjectID(), sess.Name()))
+	filter["values_format"] = bson.M{"$ne": rewritten.Format}
+	if s.LastModified.IsZero() {
+		filter["last_modified"] = bson.M{"$exists": false}
+	} else {
+		filter["last_modified"] = s.LastModified
+	}
+
+	update := updateDocFromSession(rewritten, store.unsetFields()...)
+	var modified int64
+	err = store.withRetry(ctx, func() error {
+		res, err := store.collection.UpdateOne(ctx, filter, update)
+		store.uncache(sess.ID)
+		if err != nil {
+			return err
+		}
+		modified = res.ModifiedCount
+		return nil
+	})
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to rewrite session in the target format",
+			"session_id", sess.ID,
+			"error", err,
+		)
+		return false, err
+	}
+
+	return modified > 0, nil
+}
@@ -1,6 +1,17 @@
 package sessions_mongo
 
 import (
+	"context"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"net/http"
+	"strings"
 	"time"
 )
 
@@ -9,13 +20,404 @@ import (
 type Options struct {
 	TTLOptions     TTLOptions
 	LoggingOptions LoggingOptions
+	//RequestIDFromContext, if set, extracts a request ID from the context of an operation,
+	//which is added as `request_id` to the log lines of saving, loading and deleting
+	//sessions to correlate them with request logs.  An empty ID is omitted.
+	RequestIDFromContext func(ctx context.Context) string
+	//SkipUnchangedWrites stores a hash of sess.Values alongside the session and,
+	//when Save is called with values matching the stored hash, only refreshes
+	//last_modified instead of rewriting the encoded data.
+	SkipUnchangedWrites bool
+	//DisableSaveCoalescing makes every call to Save write the session.  By default Save
+	//skips writing a session that was already saved during the same request with
+	//identical values, so middleware saving defensively causes a single write; the cookie
+	//is still written every time.
+	DisableSaveCoalescing bool
+	//KeepCookieOnDeleteError leaves the cookie untouched when saving a session with a
+	//negative MaxAge fails to delete it from the collection, so a retry can still target
+	//the session instead of orphaning it until it expires.  By default the cookie is
+	//cleared either way and the error returned.
+	KeepCookieOnDeleteError bool
+	//WriteMode controls how Save persists sessions.  Defaults to WriteModeUpsert.
+	WriteMode WriteMode
+	//RegisterTypes are registered with gob by NewMongoDBStore, e.g. []interface{}{Profile{}}.
+	//With SerializationGob every concrete type stored in sess.Values, other than the basic
+	//types, must be registered, here or by calling gob.Register; Save otherwise fails with
+	//an *UnregisteredTypeErr.  The registry is global, so types registered by one store are
+	//known to all of them.
+	RegisterTypes []interface{}
+	//ValueSerializer, if set, replaces gob for turning sess.Values into the bytes the codecs
+	//are applied to with SerializationGob.  Sessions stored with another serializer cannot
+	//be loaded.  SkipUnchangedWrites still hashes values with gob, so it requires their
+	//types to be registered.  Incompatible with SerializationBSON.
+	ValueSerializer ValueSerializer
+	//Serialization controls how sess.Values are stored.  Defaults to SerializationGob.
+	Serialization SerializationFormat
+	//Registry is used to marshal and unmarshal sess.Values when Serialization is
+	//SerializationBSON.  Defaults to a registry built from the driver's default
+	//registry that decodes int32 values as int and datetimes as time.Time.
+	Registry *bsoncodec.Registry
+	//RegisterCodecs registers additional encoders, decoders and type map entries, e.g. for
+	//custom types, on top of the default Registry.  It is called once by NewMongoDBStore
+	//and cannot be combined with Registry.
+	RegisterCodecs func(rb *bsoncodec.RegistryBuilder)
+	//ValueTypes maps keys of sess.Values to a prototype of the type their value is decoded
+	//into with SerializationBSON, e.g. {"profile": Profile{}}, so nested structs, including
+	//their time.Time and primitive.ObjectID fields, are loaded as saved rather than as
+	//bson.M
+	ValueTypes map[string]interface{}
+	//BaseFilter scopes every operation of the store, e.g. to a tenant.  Its fields are
+	//added to the filter of every query and written on every save, so a store can neither
+	//read nor overwrite sessions outside of its scope.  Values must be plain equality
+	//values rather than query operators, and keys may not collide with the store's own
+	//document fields.
+	BaseFilter bson.M
+	//IDPrefix namespaces the sessions of the store, e.g. per app in a collection shared by
+	//several apps: documents are stored with a string _id of the form "<IDPrefix>:<ID>",
+	//and every operation of the store is scoped to the _ids of its prefix.  DeleteByPrefix
+	//removes the sessions of a namespace.  The session ID held by cookies and passed to the
+	//store's methods stays the hex ObjectID.  Sessions saved without the prefix, or with
+	//another one, are out of scope once it is set.  It must not contain ":".
+	IDPrefix string
+	//ShardKeyFunc, if set, derives the shard key fields of a session from its ID.  The
+	//fields are written to the session document and added to every filter matching a
+	//single session, so those operations target one shard of a sharded collection.  Like
+	//BaseFilter, it must return plain equality values whose keys don't collide with the
+	//store's own fields, and it must be deterministic.
+	ShardKeyFunc func(sessionID string) bson.M
+	//ExtraFields, if set, is called on every save and its fields are written as top-level
+	//fields of the session document next to the encoded values, e.g. a user ID or role to
+	//query, sort or index on.  Returning a field managed by the store, by BaseFilter or by
+	//ShardKeyFunc fails the save with a *ReservedFieldErr.
+	ExtraFields func(sess *sessions.Session) bson.M
+	//IDCodec encodes and decodes the session ID transported in the cookie.  Defaults to
+	//the securecookie codecs passed to NewMongoDBStore.
+	IDCodec IDCodec
+	//AdditionalIndexes are created by NewMongoDBStore after the TTL index, e.g. on fields
+	//written through ExtraFields or UserIDField.  Creating an index that already exists
+	//with the same keys and options is a no-op; one that conflicts with an existing index
+	//fails the construction of the store.
+	AdditionalIndexes []mongo.IndexModel
+	//SkipIndexCreation makes NewMongoDBStore and WithCollection skip creating the TTL
+	//index, the SoftDelete deleted_at index and AdditionalIndexes, while still validating
+	//the options and checking the connection, e.g. to speed up test suites sharing a
+	//database.  TTLIndexEnsured then reports false.  Not meant for production, where
+	//nothing but the sweeper would expire sessions.
+	SkipIndexCreation bool
+	//LoadProjection, if set, is the projection used when loading a session, so fields
+	//added to session documents by other tooling are neither transferred nor decoded.
+	//StoreFieldsProjection includes exactly the fields managed by the store.  Fields
+	//needed by LegacyOptions.DataField must be included explicitly; fields written via
+	//BaseFilter, ShardKeyFunc or ExtraFields are not needed to load a session.
+	LoadProjection bson.M
+	//Cache, if set, serves recently loaded sessions from memory instead of the collection,
+	//e.g. NewLRUCache.  Every write through the store drops the written session from the
+	//cache, but writes by other processes or other stores, and deletions by the TTL index
+	//or DeleteExpiredSessions, are only observed once the cached entry expires.  A load
+	//racing a Save may also cache the previous version, so the cache's expiry bounds the
+	//staleness of every session and should be kept to a few seconds.
+	Cache Cache
+	//ServeStaleOnError, if set, makes loading a session fall back to the last copy of it
+	//held by Cache when the collection cannot be read, e.g. during a failover, logging a
+	//warning instead of failing the request.  Cache must be a StaleCache, e.g.
+	//NewStaleLRUCache, whose stale period bounds how old a served copy may be.  This trades
+	//security for availability: a session deleted or changed elsewhere, e.g. by a logout
+	//or a revocation, keeps being served as last seen until the collection is reachable
+	//again.  Writes still go to the collection, so a failed Save also drops the copy.
+	ServeStaleOnError bool
+	//OnInvalidate, if set, is called with the ID of every session the store deleted from
+	//the collection, after the deletion succeeded, e.g. to tell other instances to drop
+	//the session from their Cache.  It is called synchronously, so it should not block.
+	//Sessions deleted by the TTL index or DeleteExpiredSessions are not reported.
+	OnInvalidate func(sessionID string)
+	//OnDecodeFailure, if set, is called with the ID of every stored session whose values
+	//failed to decode while loading it, and the decoding error, e.g. to feed a metric
+	//alerting on corrupt sessions or a botched key rotation.  It is called synchronously,
+	//before OnDecodeError is applied, so it should not block.  DecodeFailures counts the
+	//same failures.
+	OnDecodeFailure func(sessionID string, err error)
+	//Layout determines how sessions are arranged in the collection.  Defaults to
+	//LayoutDocument.
+	Layout Layout
+	//SoftDelete keeps a tombstone of deleted sessions instead of removing them
+	SoftDelete SoftDeleteOptions
+	//CappedOptions stores sessions in a capped collection
+	CappedOptions CappedOptions
+	//OnDecodeError controls what happens when stored session values cannot be decoded.
+	//Defaults to DecodeErrorPropagate.
+	OnDecodeError DecodeErrorPolicy
+	//LegacyOptions allows loading documents written by an older mgo based session store
+	LegacyOptions LegacyOptions
+	//RetryOptions configures retrying operations that fail with a transient error
+	RetryOptions RetryOptions
+	//SweepOptions configures a background goroutine that periodically deletes expired sessions
+	SweepOptions SweepOptions
+	//RequirePrimaryOnConnect makes NewMongoDBStore and WithCollection ping the primary
+	//rather than any member, so they fail when no writable primary is reachable instead of
+	//the first save failing later.  Without a primary the ping fails once the client's
+	//server selection timeout has passed.  The health monitor then also reports the store
+	//unhealthy while no primary is reachable.
+	RequirePrimaryOnConnect bool
+	//HealthMonitor configures a background goroutine that periodically checks the
+	//connection to MongoDB
+	HealthMonitor HealthMonitorOptions
+	//UserIDField names a top-level document field holding the ID of the session's user,
+	//used by statistics about distinct users.  The store does not write this field itself.
+	UserIDField string
+	//CookieDisabled transports the session ID without cookies, e.g. behind an API gateway.
+	//Save writes the encoded ID to the HeaderName response header, or only persists the
+	//session when HeaderName is empty, leaving the caller to send EncodedID itself, and New
+	//reads the encoded ID from the HeaderName request header.  Clearing a session sets the
+	//header to an empty value.  As the header carries a single ID, a request can only
+	//use one session name at a time.
+	CookieDisabled bool
+	HeaderName     string
+	//PartitionedCookies adds the Partitioned attribute to session cookies, including the
+	//ones clearing a session, for third-party contexts using partitioned cookies (CHIPS).
+	//Browsers only accept partitioned cookies that are also Secure, so sess.Options, or an
+	//Options.CookieOptionsHook, must set Secure.
+	PartitionedCookies bool
+	//CollectionFromContext picks the collection sessions are saved to, loaded from and
+	//deleted from per request, e.g. a collection per tenant identified by a value of the
+	//request context.  The collection passed to NewMongoDBStore is used when it is nil.
+	//Errors returned by it are returned by the failing operation.  Only the collection
+	//passed to NewMongoDBStore is prepared, so indexes of resolved collections have to be
+	//created beforehand, and the administrative and maintenance methods only operate on the
+	//collection passed to NewMongoDBStore.  Incompatible with Cache, which isn't partitioned
+	//by collection.
+	CollectionFromContext func(ctx context.Context) (*mongo.Collection, error)
+	//Binding binds sessions to a fingerprint of the client that created them
+	Binding BindingOptions
+	//LegacyCookieNames are previous names of the session cookie, for renaming it without
+	//logging everyone out.  When a request lacks the cookie named after the session, New
+	//consults these names in order.  Save always writes the current name and expires any
+	//legacy cookie the request carried.  Values stored under a legacy name keep decoding
+	//until the session is rewritten.  Ignored with CookieDisabled.
+	LegacyCookieNames []string
+	//CookieOptionsHook, if set, adjusts a copy of sess.Options based on the incoming
+	//request before Save writes the cookie, e.g. SameSiteNoneOverTLS.  The stored session
+	//options are not affected.
+	CookieOptionsHook func(r *http.Request, opts *sessions.Options)
+	//Clock, if set, supplies the current time used for the timestamps written to session
+	//documents and for checking expiry, e.g. to store times in a specific location, strip
+	//the monotonic clock reading or make tests deterministic.  MongoDB stores times as UTC
+	//milliseconds, so the location is not preserved when a document is read back.
+	//Defaults to time.Now in UTC.
+	Clock func() time.Time
+	//ReadPreference, ReadConcern and WriteConcern configure the collection used by the
+	//store.  Unset values are inherited from the supplied collection.
+	ReadPreference *readpref.ReadPref
+	//ReadConcern applies to every read, including loading sessions.  `majority` avoids
+	//reading writes, such as a login, that are later rolled back after a failover, at the
+	//cost of reading slightly older data.  `linearizable` additionally guarantees reading
+	//the latest acknowledged majority write, but every read has to confirm the primary
+	//with a majority of the replica set, adding at least one round trip between members
+	//to each load, and blocks while a majority is unreachable, so callers should always
+	//bound loads with a context deadline.  `linearizable` requires a primary ReadPreference.
+	ReadConcern *readconcern.ReadConcern
+	//WriteConcern applies to every write.  With an unacknowledged concern such as w:0,
+	//writes whose outcome the driver cannot report count as successful: Save and SaveAll
+	//return nil, Delete reports false and SaveWithResult reports SaveNotWritten.  Flush
+	//waits for them to become durable.
+	WriteConcern *writeconcern.WriteConcern
 }
 
+//SameSiteNoneOverTLS is a CookieOptionsHook that issues `SameSite=None; Secure` cookies
+//for cross-site embedding when the request arrived over TLS, and falls back to
+//`SameSite=Lax` otherwise, as browsers reject SameSite=None cookies that aren't Secure.
+//The fallback leaves Secure as configured, so cookies stay Secure behind a proxy
+//terminating TLS.
+func SameSiteNoneOverTLS(r *http.Request, opts *sessions.Options) {
+	if r.TLS != nil {
+		opts.SameSite = http.SameSiteNoneMode
+		opts.Secure = true
+		return
+	}
+
+	opts.SameSite = http.SameSiteLaxMode
+}
+
+//LegacyOptions is a collection of settings regarding documents written by an older
+//mgo based session store.  Legacy documents are recognized by their missing
+//last_modified field, and are rewritten in the current format on their next Save.
+type LegacyOptions struct {
+	Enabled bool
+	//DataField is the field holding the JSON encoded session values.  Defaults to "data".
+	//JSON numbers are loaded as float64.
+	DataField string
+	//RemovedFields are additional legacy fields removed when a session is rewritten
+	RemovedFields []string
+}
+
+//DecodeErrorPolicy determines how the store handles a stored session whose values
+//cannot be decoded, e.g. because of corruption or a removed codec key
+type DecodeErrorPolicy int
+
+const (
+	//DecodeErrorPropagate returns the decode error from New
+	DecodeErrorPropagate DecodeErrorPolicy = iota
+	//DecodeErrorReset deletes the undecodable document and has New return a fresh,
+	//empty session with a new ID and no error.  NewWithID keeps the requested ID.
+	DecodeErrorReset
+)
+
+//SerializationFormat determines how sess.Values are persisted in the session document
+type SerializationFormat int
+
+const (
+	//SerializationGob gob encodes sess.Values, or serializes them with ValueSerializer, and
+	//passes them through the store's codecs, storing the opaque result in the `data` field
+	SerializationGob SerializationFormat = iota
+	//SerializationBSON stores sess.Values as a queryable BSON document in the `values`
+	//field.  The store's codecs are only applied to the session ID cookie, so values are
+	//neither signed nor encrypted.  Keys must be strings.  With the default Registry the
+	//supported value types are string, bool, float64, int, int64, time.Time (stored with
+	//millisecond precision and loaded in UTC), primitive.ObjectID, primitive.A and bson.M.
+	//Other types, including custom structs, are loaded in their generic BSON form (e.g.
+	//structs as bson.M) unless ValueTypes names their type or the Registry maps them
+	//explicitly.
+	SerializationBSON
+)
+
+//marker is the value of the values_format field of documents written in format f
+func (f SerializationFormat) marker() string {
+	if f == SerializationBSON {
+		return "bson"
+	}
+
+	return "gob"
+}
+
+//WriteMode determines the write semantics used when persisting a session
+type WriteMode int
+
+const (
+	//WriteModeUpsert upserts every session on Save regardless of whether it is new
+	WriteModeUpsert WriteMode = iota
+	//WriteModeStrict inserts new sessions and updates existing sessions without upserting.
+	//Saving a new session whose ID already exists returns a *DuplicateSessionIDErr, and
+	//saving an existing session that is no longer stored returns ErrSessionNotFound.
+	WriteModeStrict
+)
+
 //TTLOptions is a collection of settings and options regarding the TimeToLive
 //functionality of the Store
 type TTLOptions struct {
 	EnsureTTLIndex bool
 	TTL            time.Duration
+	//IndexBestEffort tolerates lacking the privilege to create the TTL index, e.g. when a
+	//DBA pre-creates indexes: the authorization error is logged as a warning and the index
+	//is assumed to exist, though TTLIndexEnsured reports false.  Any other failure to
+	//ensure the index still fails NewMongoDBStore.
+	IndexBestEffort bool
+	//AdoptExistingIndexTTL makes NewMongoDBStore use the expireAfterSeconds of an existing
+	//TTL index on last_modified as TTL, and so as the default MaxAge, keeping the store in
+	//line with an index managed elsewhere.  TTL is still required and used if no such
+	//index exists.  Stores derived with WithCollection keep the adopted TTL.
+	AdoptExistingIndexTTL bool
+	//EnforceOnRead treats sessions last modified more than TTL ago as not found when
+	//loading, instead of relying on the TTL monitor, which only runs periodically, to
+	//have removed them
+	EnforceOnRead bool
+	//GracePeriod extends the validity of sessions enforced on read beyond TTL
+	GracePeriod time.Duration
+	//TTLByName overrides TTL for sessions with the given names.  It determines the
+	//expires_at written on save, which read enforcement and DeleteExpiredSessions honor.
+	//The TTL index still removes every session TTL after its last modification, so
+	//durations longer than TTL are cut short when the index is in place.
+	TTLByName map[string]time.Duration
+	//ClockSkew tolerates clocks of the fleet being out of sync by up to this duration:
+	//sessions enforced on read, and sessions deleted by DeleteExpiredSessions, only count
+	//as expired ClockSkew after their expiry.  Defaults to 0.
+	ClockSkew time.Duration
+	//Jitter randomizes the expires_at of every save within ±Jitter of its TTL, so sessions
+	//created in a burst don't all expire at once.  It applies to read enforcement and
+	//DeleteExpiredSessions; the TTL index still removes every session TTL after its last
+	//modification, cutting short sessions jittered beyond TTL where the index is in place.
+	//It must be shorter than every TTL.
+	Jitter time.Duration
+	//RefreshOnLoad refreshes last_modified and expires_at of every session loaded by New
+	//or NewWithID, for sliding expiry without saving on every request.  A failed refresh
+	//is logged and doesn't fail the load.  The refresh stands in for a Save of the
+	//unchanged session later in the same request, unless DisableSaveCoalescing is set.
+	//Documents read through LegacyOptions are not refreshed, as that would stop them
+	//being recognized as legacy; saving them rewrites them in the current format.
+	RefreshOnLoad bool
+}
+
+//RetryOptions is a collection of settings regarding retrying idempotent operations
+//that fail with a transient error, such as a network error or a primary stepdown.
+//Loading, the upsert and update performed by Save, and deleting are retried.  Inserts
+//made in WriteModeStrict and SaveAll are never retried.  Retries stop early once the
+//operation's context is done.
+type RetryOptions struct {
+	//MaxAttempts is the total number of attempts, including the first.  Values below 2
+	//disable retries.
+	MaxAttempts int
+	//Backoff is the delay before the first retry, doubled for every subsequent retry
+	Backoff time.Duration
+}
+
+//SweepOptions is a collection of settings regarding the background sweeper, which
+//calls Sweep every Interval until the store is closed
+type SweepOptions struct {
+	Enabled  bool
+	Interval time.Duration
+	//Untimestamped makes every sweep also call DeleteUntimestampedSessions, deleting
+	//sessions without last_modified whose ObjectID is older than the TTL
+	Untimestamped bool
+}
+
+//HealthMonitorOptions is a collection of settings regarding the background health
+//monitor, which pings MongoDB every Interval until the store is closed and logs when the
+//connection is lost and when it recovers, giving early warning before saves and loads
+//fail.  Healthy reports the result of the last check.
+type HealthMonitorOptions struct {
+	Enabled  bool
+	Interval time.Duration
+}
+
+//CappedOptions is a collection of settings regarding storing sessions in a capped
+//collection, where the oldest sessions roll off by insertion order once SizeBytes or
+//MaxDocs is reached, instead of expiring by TTL.  The collection is created if it doesn't
+//exist; an existing collection must already be capped.  TTL indexes are not supported on
+//capped collections, so EnsureTTLIndex must be false.
+//
+//MongoDB rejects updates that change the size of a document in a capped collection, so
+//Save replaces the stored session by deleting its document and inserting the new one,
+//which also moves the session to the end of the insertion order.  A session is briefly
+//absent during the replacement, and lost if the insert fails.  SaveAll, UpdateValues,
+//MigrateFormat and SoftDelete update documents in place and are not supported.
+//Deleting sessions, and therefore saving and clearing them via Save, is only supported by
+//MongoDB 5.0 and later.
+type CappedOptions struct {
+	Enabled   bool
+	SizeBytes int64
+	//MaxDocs optionally limits the number of sessions as well.  0 means no limit.
+	MaxDocs int64
+}
+
+//BindingOptions is a collection of settings regarding binding sessions to the client that
+//created them, defending against stolen cookies.  Save records a hash of the
+//FingerprintFunc of the request when it creates the stored session, which later saves
+//never change, and ValidateBound compares the fingerprint of a request with it.
+//FingerprintFunc defaults to DefaultFingerprint.
+type BindingOptions struct {
+	Enabled         bool
+	FingerprintFunc func(r *http.Request) string
+}
+
+//SoftDeleteOptions is a collection of settings regarding retaining deleted sessions, e.g. as an
+//audit trail of logouts.  Clearing a session through Save, Delete and discarding an
+//undecodable session set deleted_at on the document instead of removing it, and loading a
+//session with deleted_at set behaves as if it didn't exist.  NewMongoDBStore creates a
+//TTL index on deleted_at removing tombstones RetainFor after the deletion; last_modified
+//and expires_at are removed from tombstones so the session TTL doesn't cut RetainFor
+//short.  Saving a session under the ID of a tombstone replaces the tombstone.  The
+//metadata and maintenance methods, such as Stats and IterateSessions, include tombstones.
+type SoftDeleteOptions struct {
+	Enabled   bool
+	RetainFor time.Duration
 }
 
 //LoggingOptions is a collection of settings and options regarding the logging
@@ -24,6 +426,27 @@ type LoggingOptions struct {
 	Enabled bool
 }
 
+//collectionOptions returns the options the store applies to its collection, or nil
+//if the supplied collection is used as is
+func (o Options) collectionOptions() *options.CollectionOptions {
+	if o.ReadPreference == nil && o.ReadConcern == nil && o.WriteConcern == nil {
+		return nil
+	}
+
+	opts := options.Collection()
+	if o.ReadPreference != nil {
+		opts.SetReadPreference(o.ReadPreference)
+	}
+	if o.ReadConcern != nil {
+		opts.SetReadConcern(o.ReadConcern)
+	}
+	if o.WriteConcern != nil {
+		opts.SetWriteConcern(o.WriteConcern)
+	}
+
+	return opts
+}
+
 //Validate does a sanity check on relevant options that can be modified by
 //an implementing developer.
 func (o Options) Validate() error {
@@ -31,5 +454,90 @@ func (o Options) Validate() error {
 		return NewInvalidTTLErr(o.TTLOptions.TTL)
 	}
 
+	for _, ttl := range o.TTLOptions.TTLByName {
+		if ttl <= 0 {
+			return NewInvalidTTLErr(ttl)
+		}
+		if o.TTLOptions.Jitter >= ttl {
+			return NewIncompatibleOptionsErr("TTLOptions.Jitter must be shorter than every TTL")
+		}
+	}
+
+	if o.TTLOptions.Jitter < 0 || o.TTLOptions.Jitter >= o.TTLOptions.TTL {
+		return NewIncompatibleOptionsErr("TTLOptions.Jitter must be between 0 and TTL")
+	}
+
+	if o.ReadConcern != nil && o.ReadConcern.GetLevel() == "linearizable" &&
+		o.ReadPreference != nil && o.ReadPreference.Mode() != readpref.PrimaryMode {
+		return NewIncompatibleOptionsErr("linearizable ReadConcern requires a primary ReadPreference")
+	}
+
+	if o.Layout == LayoutSubdocument {
+		if err := o.validateSubdocumentLayout(); err != nil {
+			return err
+		}
+	}
+
+	if o.CappedOptions.Enabled {
+		if o.CappedOptions.SizeBytes <= 0 {
+			return NewInvalidCappedSizeErr(o.CappedOptions.SizeBytes)
+		}
+		if o.TTLOptions.EnsureTTLIndex {
+			return NewIncompatibleOptionsErr("TTL indexes cannot be created on capped collections")
+		}
+		if o.SoftDelete.Enabled {
+			return NewIncompatibleOptionsErr("SoftDelete is not supported with CappedOptions")
+		}
+	}
+
+	for _, t := range o.RegisterTypes {
+		if t == nil {
+			return NewIncompatibleOptionsErr("RegisterTypes cannot contain nil")
+		}
+	}
+
+	if o.ValueSerializer != nil && o.Serialization == SerializationBSON {
+		return NewIncompatibleOptionsErr("ValueSerializer cannot be combined with SerializationBSON")
+	}
+
+	if o.Registry != nil && o.RegisterCodecs != nil {
+		return NewIncompatibleOptionsErr("RegisterCodecs cannot be combined with Registry")
+	}
+
+	if o.CollectionFromContext != nil && o.Cache != nil {
+		return NewIncompatibleOptionsErr("Cache cannot be combined with CollectionFromContext")
+	}
+
+	if _, ok := o.Cache.(StaleCache); o.ServeStaleOnError && !ok {
+		return NewIncompatibleOptionsErr("ServeStaleOnError requires Cache to be a StaleCache")
+	}
+
+	if o.SoftDelete.Enabled && o.SoftDelete.RetainFor <= 0 {
+		return NewInvalidIntervalErr("SoftDelete.RetainFor", o.SoftDelete.RetainFor)
+	}
+
+	if o.SweepOptions.Enabled && o.SweepOptions.Interval <= 0 {
+		return NewInvalidIntervalErr("SweepOptions.Interval", o.SweepOptions.Interval)
+	}
+
+	if o.HealthMonitor.Enabled && o.HealthMonitor.Interval <= 0 {
+		return NewInvalidIntervalErr("HealthMonitor.Interval", o.HealthMonitor.Interval)
+	}
+
+	if o.LegacyOptions.Enabled && o.LegacyOptions.DataField != "" &&
+		o.LegacyOptions.DataField != "data" && isReservedField(o.LegacyOptions.DataField) {
+		return NewReservedFieldErr(o.LegacyOptions.DataField)
+	}
+
+	for k := range o.BaseFilter {
+		if isReservedField(k) {
+			return NewReservedFieldErr(k)
+		}
+	}
+
+	if strings.Contains(o.IDPrefix, idPrefixSeparator) {
+		return NewInvalidIDPrefixErr(o.IDPrefix)
+	}
+
 	return nil
 }
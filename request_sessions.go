@@ -0,0 +1,44 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"sync"
+)
+
+type requestSessionsKey struct{}
+
+//requestSessions holds the fresh sessions New created during a request for clients that
+//sent no session ID, so calling New again returns the same session instead of a new ID
+type requestSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*sessions.Session
+}
+
+//freshSession returns the session New already created for name during r, if any
+func freshSession(r *http.Request, name string) (*sessions.Session, bool) {
+	rs, ok := r.Context().Value(requestSessionsKey{}).(*requestSessions)
+	if !ok {
+		return nil, false
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	sess, ok := rs.sessions[name]
+	return sess, ok
+}
+
+//rememberFreshSession records sess for later New calls during r, attaching the record to
+//the context of r like sessions.GetRegistry does
+func rememberFreshSession(r *http.Request, sess *sessions.Session) {
+	rs, ok := r.Context().Value(requestSessionsKey{}).(*requestSessions)
+	if !ok {
+		rs = &requestSessions{sessions: make(map[string]*sessions.Session)}
+		*r = *r.WithContext(context.WithValue(r.Context(), requestSessionsKey{}, rs))
+	}
+
+	rs.mu.Lock()
+	rs.sessions[sess.Name()] = sess
+	rs.mu.Unlock()
+}
@@ -0,0 +1,66 @@
+package sessions_mongo
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log/level"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+//retryableCodes are the server error codes the driver itself treats as retryable
+var retryableCodes = map[int]bool{
+	6: true, 7: true, 89: true, 91: true, 189: true, 262: true, 9001: true,
+	10107: true, 11600: true, 11602: true, 13435: true, 13436: true,
+}
+
+type labeledError interface {
+	HasErrorLabel(label string) bool
+}
+
+func isRetryableError(err error) bool {
+	var labeled labeledError
+	if errors.As(err, &labeled) &&
+		(labeled.HasErrorLabel("NetworkError") || labeled.HasErrorLabel("RetryableWriteError")) {
+		return true
+	}
+
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		return retryableCodes[int(ce.Code)]
+	}
+
+	var we mongo.WriteException
+	if errors.As(err, &we) && we.WriteConcernError != nil {
+		return retryableCodes[we.WriteConcernError.Code]
+	}
+
+	return false
+}
+
+//withRetry runs fn, retrying it according to Options.RetryOptions while it fails with
+//a retryable error and ctx is not done.  The last error from fn is returned.
+func (store *MongoDBStore) withRetry(ctx context.Context, fn func() error) error {
+	backoff := store.storeOptions.RetryOptions.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= store.storeOptions.RetryOptions.MaxAttempts || !isRetryableError(err) {
+			return err
+		}
+
+		_ = level.Warn(store.contextLogger(ctx)).Log(
+			"message", "retrying operation after transient error",
+			"attempt", attempt,
+			"error", err,
+		)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,58 @@
+package sessions_mongo
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+	"time"
+)
+
+func TestMongoDBStore_withRetry(t *testing.T) {
+	transient := mongo.CommandError{Code: 91, Message: "shutdown in progress"}
+	store := &MongoDBStore{
+		logger:       log.NewNopLogger(),
+		storeOptions: Options{RetryOptions: RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond}},
+	}
+
+	attempts := 0
+	err := store.withRetry(context.Background(), func() error {
+		attempts++
+		return transient
+	})
+	assert.Equal(t, transient, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = store.withRetry(context.Background(), func() error {
+		attempts++
+		if attempts < 2 {
+			return transient
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+
+	attempts = 0
+	permanent := errors.New("permanent")
+	err = store.withRetry(context.Background(), func() error {
+		attempts++
+		return permanent
+	})
+	assert.Equal(t, permanent, err)
+	assert.Equal(t, 1, attempts)
+
+	attempts = 0
+	store.storeOptions.RetryOptions.Backoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = store.withRetry(ctx, func() error {
+		attempts++
+		return transient
+	})
+	assert.Equal(t, transient, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,114 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"net/http"
+)
+
+type writeConcernKey struct{}
+
+type cookieOptionsKey struct{}
+
+type saveResultKey struct{}
+
+//SaveResult reports what SaveWithResult did to the stored session
+type SaveResult int
+
+const (
+	//SaveNotWritten means the session wasn't written, as an identical save of the same
+	//request already wrote it, or that the outcome of the write is unknown, as its write
+	//concern is unacknowledged
+	SaveNotWritten SaveResult = iota
+	//SaveCreated means a new document was stored for the session
+	SaveCreated
+	//SaveUpdated means the existing document of the session was rewritten
+	SaveUpdated
+	//SaveTouched means only the expiry of the unchanged session was refreshed, see
+	//Options.SkipUnchangedWrites
+	SaveTouched
+	//SaveDeleted means the session was deleted, as its MaxAge was negative
+	SaveDeleted
+)
+
+//SaveOptions override store-wide settings for a single SaveWithOptions call.  Zero
+//values keep the store's settings.
+type SaveOptions struct {
+	//WriteConcern overrides Options.WriteConcern, e.g. w:majority for the save right after
+	//a login while other saves stay fast
+	WriteConcern *writeconcern.WriteConcern
+	//CookieOptions replace sess.Options for the cookie issued by this save, e.g. a session
+	//cookie for a login without "remember me", without changing the options of sess.
+	//Options.CookieOptionsHook still applies to a copy of them.
+	CookieOptions *sessions.Options
+}
+
+//SaveWithOptions saves sess like Save, applying saveOpts to this save only.  A save with
+//a WriteConcern override is always written, even if the same values were already saved
+//during the request.
+func (store *MongoDBStore) SaveWithOptions(
+	r *http.Request,
+	w http.ResponseWriter,
+	sess *sessions.Session,
+	saveOpts SaveOptions,
+) error {
+	if saveOpts.WriteConcern == nil && saveOpts.CookieOptions == nil {
+		return store.Save(r, w, sess)
+	}
+
+	//the saves of the request are recorded on r itself, so later plain saves coalesce
+	//with this one
+	store.requestSaves(r)
+	ctx := r.Context()
+	if saveOpts.WriteConcern != nil {
+		ctx = context.WithValue(ctx, writeConcernKey{}, saveOpts.WriteConcern)
+	}
+	if saveOpts.CookieOptions != nil {
+		ctx = context.WithValue(ctx, cookieOptionsKey{}, saveOpts.CookieOptions)
+	}
+
+	return store.Save(r.WithContext(ctx), w, sess)
+}
+
+//SaveWithCookieOptions persists sess like Save but issues the cookie with opts instead of
+//sess.Options, leaving sess.Options untouched.  See SaveOptions.CookieOptions.
+func (store *MongoDBStore) SaveWithCookieOptions(
+	r *http.Request,
+	w http.ResponseWriter,
+	sess *sessions.Session,
+	opts *sessions.Options,
+) error {
+	return store.SaveWithOptions(r, w, sess, SaveOptions{CookieOptions: opts})
+}
+
+//SaveWithResult saves sess like Save and reports whether the save created the stored
+//session or updated it, e.g. for counting new sessions.  A failed save reports the
+//outcome of the writes that succeeded before the failure, usually SaveNotWritten.
+func (store *MongoDBStore) SaveWithResult(
+	r *http.Request,
+	w http.ResponseWriter,
+	sess *sessions.Session,
+) (SaveResult, error) {
+	//the saves of the request are recorded on r itself, so later plain saves coalesce
+	//with this one
+	store.requestSaves(r)
+	result := SaveNotWritten
+	ctx := context.WithValue(r.Context(), saveResultKey{}, &result)
+	err := store.Save(r.WithContext(ctx), w, sess)
+
+	return result, err
+}
+
+//recordSaveResult reports result to the SaveWithResult call ctx belongs to, if any
+func recordSaveResult(ctx context.Context, result SaveResult) {
+	if dst, ok := ctx.Value(saveResultKey{}).(*SaveResult); ok {
+		*dst = result
+	}
+}
+
+//writeConcernOverride returns the WriteConcern of the SaveWithOptions call ctx belongs to
+func writeConcernOverride(ctx context.Context) *writeconcern.WriteConcern {
+	wc, _ := ctx.Value(writeConcernKey{}).(*writeconcern.WriteConcern)
+	return wc
+}
@@ -0,0 +1,215 @@
+package sessions_mongo
+
+import (
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//ValueSerializer turns session values into bytes and back, e.g. as JSON, msgpack or
+//protobuf, for Options.ValueSerializer.  The store's codecs are applied to the serialized
+//bytes, so values stay signed and encrypted.  Implementations must be safe for
+//concurrent use.
+type ValueSerializer interface {
+	Serialize(values map[interface{}]interface{}) ([]byte, error)
+	Deserialize(data []byte) (map[interface{}]interface{}, error)
+}
+
+//DecodeValues decodes a raw `data` string, as stored by SerializationGob, into session
+//values using the store's ValueSerializer and codecs, without any network I/O.  The
+//session name is required because the codecs bind it into the encoded data.
+func (store *MongoDBStore) DecodeValues(name, data string) (map[interface{}]interface{}, error) {
+	return decodeData(name, data, store.storeOptions.ValueSerializer, store.codecs...)
+}
+
+//EncodeValues produces the raw `data` string SerializationGob stores for a session named
+//name holding values, using the store's ValueSerializer and codecs.  It is the
+//counterpart of DecodeValues, e.g. for seeding fixtures or pre-populating sessions from a
+//migration script.
+func (store *MongoDBStore) EncodeValues(name string, values map[interface{}]interface{}) (string, error) {
+	return encodeData(name, values, store.storeOptions.ValueSerializer, store.codecs...)
+}
+
+//encodeData encodes values for the `data` field with serializer, or gob if it is nil,
+//and codecs.  The gob encoding happens inside the codecs' serializer, which belongs to
+//securecookie, so unlike marshalBSONValues and hashValues its buffers are not pooled.
+func encodeData(
+	name string,
+	values map[interface{}]interface{},
+	serializer ValueSerializer,
+	codecs ...securecookie.Codec,
+) (string, error) {
+	if serializer == nil {
+		return encodeValues(name, values, codecs...)
+	}
+
+	serialized, err := serializer.Serialize(values)
+	if err != nil {
+		return "", err
+	}
+
+	return securecookie.EncodeMulti(name, serialized, codecs...)
+}
+
+//decodeData is the counterpart of encodeData
+func decodeData(
+	name, data string,
+	serializer ValueSerializer,
+	codecs ...securecookie.Codec,
+) (map[interface{}]interface{}, error) {
+	if serializer == nil {
+		values := make(map[interface{}]interface{})
+		if err := securecookie.DecodeMulti(name, data, &values, codecs...); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+
+	var serialized []byte
+	if err := securecookie.DecodeMulti(name, data, &serialized, codecs...); err != nil {
+		return nil, err
+	}
+	values, err := serializer.Deserialize(serialized)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = make(map[interface{}]interface{})
+	}
+
+	return values, nil
+}
+
+var defaultValuesRegistry = newDefaultValuesRegistryBuilder().Build()
+
+//newDefaultValuesRegistryBuilder starts from the driver's default registry, decoding
+//int32 values as int, datetimes as time.Time and embedded documents as bson.M
+func newDefaultValuesRegistryBuilder() *bsoncodec.RegistryBuilder {
+	return bson.NewRegistryBuilder().
+		RegisterTypeMapEntry(bsontype.Int32, reflect.TypeOf(int(0))).
+		RegisterTypeMapEntry(bsontype.DateTime, reflect.TypeOf(time.Time{})).
+		RegisterTypeMapEntry(bsontype.EmbeddedDocument, reflect.TypeOf(bson.M{}))
+}
+
+//newValuesRegistry builds the registry for sess.Values configured by o: Options.Registry,
+//or the default registry extended by Options.RegisterCodecs
+func newValuesRegistry(o Options) *bsoncodec.Registry {
+	if o.Registry != nil {
+		return o.Registry
+	}
+	if o.RegisterCodecs == nil {
+		return defaultValuesRegistry
+	}
+
+	rb := newDefaultValuesRegistryBuilder()
+	o.RegisterCodecs(rb)
+	return rb.Build()
+}
+
+func bsonSessionFromGorillaSession(sess *sessions.Session, registry *bsoncodec.Registry) (session, error) {
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	if err != nil {
+		return session{}, err
+	}
+
+	values, err := marshalBSONValues(sess.Values, registry)
+	if err != nil {
+		return session{}, err
+	}
+
+	now := currentTime()
+	return session{
+		ID:           documentID{oid: oid},
+		Values:       values,
+		LastModified: now,
+		CreatedAt:    now,
+	}, nil
+}
+
+//maxPooledBSONBuffer caps the buffers kept by bsonBuffers
+const maxPooledBSONBuffer = 64 << 10
+
+//bsonBuffers recycles the buffers of marshalBSONValues, which runs on every Save with
+//SerializationBSON, so a session no longer reallocates its document while growing it
+var bsonBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 1024)
+		return &buf
+	},
+}
+
+//bsonDocuments recycles the documents marshalBSONValues copies the values into
+var bsonDocuments = sync.Pool{
+	New: func() interface{} {
+		return bson.M{}
+	},
+}
+
+func marshalBSONValues(values map[interface{}]interface{}, registry *bsoncodec.Registry) (bson.Raw, error) {
+	doc := bsonDocuments.Get().(bson.M)
+	defer func() {
+		for key := range doc {
+			delete(doc, key)
+		}
+		bsonDocuments.Put(doc)
+	}()
+	for k, v := range values {
+		key, ok := k.(string)
+		if !ok {
+			return nil, NewNonStringValueKeyErr(k)
+		}
+		doc[key] = v
+	}
+
+	buf := bsonBuffers.Get().(*[]byte)
+	defer bsonBuffers.Put(buf)
+	marshaled, err := bson.MarshalAppendWithRegistry(registry, (*buf)[:0], doc)
+	if err != nil {
+		return nil, err
+	}
+	//keep the grown buffer for the next call, unless an outsized session would pin it;
+	//the document outlives this call, so it is copied
+	if cap(marshaled) <= maxPooledBSONBuffer {
+		*buf = marshaled
+	}
+
+	return append(bson.Raw(nil), marshaled...), nil
+}
+
+//unmarshalBSONValues decodes raw into session values.  Values whose key is in valueTypes
+//are decoded into the type of the given prototype, other values into their generic form.
+func unmarshalBSONValues(
+	raw bson.Raw,
+	registry *bsoncodec.Registry,
+	valueTypes map[string]interface{},
+) (map[interface{}]interface{}, error) {
+	var doc bson.M
+	if err := bson.UnmarshalWithRegistry(registry, raw, &doc); err != nil {
+		return nil, err
+	}
+
+	values := make(map[interface{}]interface{}, len(doc))
+	for k, v := range doc {
+		values[k] = v
+	}
+
+	for key, prototype := range valueTypes {
+		rawValue, err := raw.LookupErr(key)
+		if err != nil {
+			continue
+		}
+		typed := reflect.New(reflect.TypeOf(prototype))
+		if err = rawValue.UnmarshalWithRegistry(registry, typed.Interface()); err != nil {
+			return nil, err
+		}
+		values[key] = typed.Elem().Interface()
+	}
+
+	return values, nil
+}
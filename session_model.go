@@ -1,40 +1,229 @@
 package sessions_mongo
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+//idPrefixSeparator separates Options.IDPrefix from the ObjectID in string _ids
+const idPrefixSeparator = ":"
+
+//documentID is the _id of a session document: the ObjectID of the session or, with
+//Options.IDPrefix, the string "<prefix>:<hex ObjectID>"
+type documentID struct {
+	prefix string
+	oid    primitive.ObjectID
+}
+
+func (id documentID) Hex() string {
+	return id.oid.Hex()
+}
+
+func (id documentID) String() string {
+	if id.prefix == "" {
+		return id.oid.String()
+	}
+	return id.prefix + idPrefixSeparator + id.oid.Hex()
+}
+
+func (id documentID) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if id.prefix == "" {
+		return bsontype.ObjectID, bsoncore.AppendObjectID(nil, id.oid), nil
+	}
+	return bsontype.String, bsoncore.AppendString(nil, id.String()), nil
+}
+
+func (id *documentID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bsoncore.Value{Type: t, Data: data}
+	if oid, ok := value.ObjectIDOK(); ok {
+		*id = documentID{oid: oid}
+		return nil
+	}
+
+	str, ok := value.StringValueOK()
+	if !ok {
+		return fmt.Errorf("cannot decode %v into a session _id", t)
+	}
+	i := strings.LastIndex(str, idPrefixSeparator)
+	if i < 0 {
+		return fmt.Errorf("session _id %q has no ID prefix", str)
+	}
+	oid, err := primitive.ObjectIDFromHex(str[i+len(idPrefixSeparator):])
+	if err != nil {
+		return err
+	}
+	*id = documentID{prefix: str[:i], oid: oid}
+
+	return nil
+}
+
 type session struct {
-	ID           primitive.ObjectID `bson:"_id"`
-	Data         string             `bson:"data"`
-	LastModified time.Time          `bson:"last_modified"`
+	ID           documentID `bson:"_id"`
+	Name         string     `bson:"name,omitempty"`
+	Data         string     `bson:"data"`
+	Values       bson.Raw   `bson:"values,omitempty"`
+	ValuesHash   string     `bson:"values_hash,omitempty"`
+	Format       string     `bson:"values_format,omitempty"`
+	LastModified time.Time  `bson:"last_modified"`
+	CreatedAt    time.Time  `bson:"created_at,omitempty"`
+	ExpiresAt    time.Time  `bson:"expires_at,omitempty"`
+	DeletedAt    time.Time  `bson:"deleted_at,omitempty"`
+	Fingerprint  string     `bson:"fingerprint,omitempty"`
+	Extra        bson.M     `bson:",inline"`
 }
 
 func (s session) ObjectID() primitive.ObjectID {
-	return s.ID
+	return s.ID.oid
 }
 
-func sessionFromGorillaSession(sess *sessions.Session, codecs ...securecookie.Codec) (session, error) {
+//SessionInfo is the metadata of a stored session, without its encoded values
+type SessionInfo struct {
+	ID           string
+	LastModified time.Time
+	//CreatedAt is the zero time for sessions stored before created_at was recorded
+	CreatedAt time.Time
+}
+
+func (s session) info() SessionInfo {
+	return SessionInfo{
+		ID:           s.ID.Hex(),
+		LastModified: s.LastModified,
+		CreatedAt:    s.CreatedAt,
+	}
+}
+
+func sessionFromGorillaSession(
+	sess *sessions.Session,
+	serializer ValueSerializer,
+	codecs ...securecookie.Codec,
+) (session, error) {
 	oid, err := primitive.ObjectIDFromHex(sess.ID)
 	if err != nil {
 		return session{}, err
 	}
 
-	encodedValues, err := securecookie.EncodeMulti(sess.Name(), sess.Values, codecs...)
+	encodedValues, err := encodeData(sess.Name(), sess.Values, serializer, codecs...)
 	if err != nil {
 		return session{}, err
 	}
 
+	now := currentTime()
 	return session{
-		ID:           oid,
+		ID:           documentID{oid: oid},
 		Data:         encodedValues,
-		LastModified: currentTime(),
+		LastModified: now,
+		CreatedAt:    now,
 	}, nil
 }
 
+func encodeValues(name string, values map[interface{}]interface{}, codecs ...securecookie.Codec) (string, error) {
+	encoded, err := securecookie.EncodeMulti(name, values, codecs...)
+	if err != nil {
+		return "", asUnregisteredTypeErr(err)
+	}
+
+	return encoded, nil
+}
+
+//registerTypes registers every type of Options.RegisterTypes with gob, turning the panic
+//of gob.Register on a conflicting registration into an error
+func registerTypes(types []interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("registering types: %v", r)
+		}
+	}()
+
+	for _, t := range types {
+		gob.Register(t)
+	}
+
+	return nil
+}
+
+//hashBuffers recycles the gob buffers of hashValues, which runs on every Save
+var hashBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+//hashValues produces a stable digest of values.  Map iteration order is random,
+//so every entry is gob encoded on its own and the sorted entry digests are hashed
+//together.  A gob encoder only sends a type's definition the first time it encodes
+//it, so every entry gets a fresh encoder; the buffer and the single entry map are
+//reused instead.
+func hashValues(values map[interface{}]interface{}) (string, error) {
+	buf := hashBuffers.Get().(*bytes.Buffer)
+	defer hashBuffers.Put(buf)
+
+	entryHashes := make([][sha256.Size]byte, 0, len(values))
+	entry := make(map[interface{}]interface{}, 1)
+	for k, v := range values {
+		buf.Reset()
+		entry[k] = v
+		err := gob.NewEncoder(buf).Encode(entry)
+		delete(entry, k)
+		if err != nil {
+			return "", asUnregisteredTypeErr(err)
+		}
+		entryHashes = append(entryHashes, sha256.Sum256(buf.Bytes()))
+	}
+	sort.Slice(entryHashes, func(i, j int) bool {
+		return bytes.Compare(entryHashes[i][:], entryHashes[j][:]) < 0
+	})
+
+	h := sha256.New()
+	for i := range entryHashes {
+		h.Write(entryHashes[i][:])
+	}
+
+	var sum [sha256.Size]byte
+	return hex.EncodeToString(h.Sum(sum[:0])), nil
+}
+
+//reservedFields are the document fields managed by the store
+var reservedFields = []string{
+	"_id", "name", "data", "values", "values_hash", "values_format", "last_modified", "created_at", "expires_at",
+	"deleted_at", "fingerprint",
+}
+
+//isReservedField reports whether field is managed by the store and may not be
+//supplied by an implementing developer
+func isReservedField(field string) bool {
+	for _, reserved := range reservedFields {
+		if field == reserved {
+			return true
+		}
+	}
+
+	return false
+}
+
+//StoreFieldsProjection returns a projection including only the document fields managed
+//by the store, for use as Options.LoadProjection
+func StoreFieldsProjection() bson.M {
+	projection := make(bson.M, len(reservedFields))
+	for _, field := range reservedFields {
+		projection[field] = 1
+	}
+
+	return projection
+}
+
 func currentTime() time.Time {
 	return time.Now().UTC()
 }
@@ -0,0 +1,175 @@
+package sessions_mongo
+
+import (
+	"errors"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	City string
+	Zip  string
+}
+
+type testProfile struct {
+	UserID    primitive.ObjectID
+	Name      string
+	LastLogin time.Time
+	Address   testAddress
+	Tags      []string
+}
+
+func TestBSONValuesRoundTrip_NestedStruct(t *testing.T) {
+	profile := testProfile{
+		UserID:    primitive.NewObjectID(),
+		Name:      "gopher",
+		LastLogin: time.Now().UTC().Truncate(time.Millisecond),
+		Address:   testAddress{City: "Denver", Zip: "80202"},
+		Tags:      []string{"admin"},
+	}
+	sess := sessions.NewSession(nil, "key")
+	sess.ID = primitive.NewObjectID().Hex()
+	sess.Values = map[interface{}]interface{}{"profile": profile, "visits": 3}
+
+	s, err := bsonSessionFromGorillaSession(sess, defaultValuesRegistry)
+	require.Nil(t, err)
+
+	values, err := unmarshalBSONValues(s.Values, defaultValuesRegistry, map[string]interface{}{"profile": testProfile{}})
+	require.Nil(t, err)
+	assert.Equal(t, sess.Values, values)
+
+	generic, err := unmarshalBSONValues(s.Values, defaultValuesRegistry, nil)
+	require.Nil(t, err)
+	assert.IsType(t, bson.M{}, generic["profile"])
+}
+
+func TestNewValuesRegistry_RegisterCodecs(t *testing.T) {
+	registry := newValuesRegistry(Options{RegisterCodecs: func(rb *bsoncodec.RegistryBuilder) {
+		rb.RegisterTypeMapEntry(bsontype.Double, reflect.TypeOf(float32(0)))
+	}})
+
+	raw, err := marshalBSONValues(map[interface{}]interface{}{"f": 1.5, "i": 5}, registry)
+	require.Nil(t, err)
+	values, err := unmarshalBSONValues(raw, registry, nil)
+	require.Nil(t, err)
+	assert.Equal(t, float32(1.5), values["f"])
+	assert.Equal(t, 5, values["i"], "the default type map entries should be kept")
+}
+
+type unregisteredValue struct {
+	Name string
+}
+
+func TestEncodeValues_UnregisteredType(t *testing.T) {
+	codecs := securecookie.CodecsFromPairs([]byte("secret-key"))
+	values := map[interface{}]interface{}{"value": unregisteredValue{Name: "a"}}
+
+	_, err := encodeValues("key", values, codecs...)
+	var unregisteredErr *UnregisteredTypeErr
+	require.True(t, errors.As(err, &unregisteredErr))
+	assert.Contains(t, unregisteredErr.TypeName(), "unregisteredValue")
+	_, err = hashValues(values)
+	assert.True(t, errors.As(err, &unregisteredErr))
+
+	require.Nil(t, registerTypes([]interface{}{unregisteredValue{}}))
+	_, err = encodeValues("key", values, codecs...)
+	assert.Nil(t, err)
+}
+
+func TestEncodeValues_JSONTypes(t *testing.T) {
+	codecs := securecookie.CodecsFromPairs([]byte("secret-key"))
+	values := map[interface{}]interface{}{
+		"profile": map[string]interface{}{"name": "gopher"},
+		"roles":   []interface{}{"admin", "user"},
+	}
+
+	registerJSONTypes()
+	encoded, err := encodeValues("key", values, codecs...)
+	require.Nil(t, err)
+	decoded, err := decodeData("key", encoded, nil, codecs...)
+	require.Nil(t, err)
+	assert.Equal(t, values, decoded)
+}
+
+func TestHashValues(t *testing.T) {
+	first, err := hashValues(map[interface{}]interface{}{
+		"key": "value",
+		"i":   5,
+		"f":   10.5,
+	})
+	require.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		again, err := hashValues(map[interface{}]interface{}{
+			"f":   10.5,
+			"i":   5,
+			"key": "value",
+		})
+		require.Nil(t, err)
+		assert.Equal(t, first, again)
+	}
+
+	changed, err := hashValues(map[interface{}]interface{}{
+		"key": "value",
+		"i":   6,
+		"f":   10.5,
+	})
+	require.Nil(t, err)
+	assert.NotEqual(t, first, changed)
+}
+
+func TestBSONValuesRoundTrip(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	sess := sessions.NewSession(nil, "key")
+	sess.ID = primitive.NewObjectID().Hex()
+	sess.Values = map[interface{}]interface{}{
+		"key":  "value",
+		"i":    5,
+		"f":    10.5,
+		"b":    true,
+		"t":    now,
+		"nest": bson.M{"a": "b"},
+	}
+
+	s, err := bsonSessionFromGorillaSession(sess, defaultValuesRegistry)
+	require.Nil(t, err)
+	assert.Empty(t, s.Data)
+
+	values, err := unmarshalBSONValues(s.Values, defaultValuesRegistry, nil)
+	require.Nil(t, err)
+	assert.Equal(t, sess.Values, values)
+}
+
+func TestBSONValuesNonStringKey(t *testing.T) {
+	_, err := marshalBSONValues(map[interface{}]interface{}{1: "one"}, defaultValuesRegistry)
+	assert.IsType(t, &NonStringValueKeyErr{}, err)
+}
+
+func TestDocumentIDRoundTrip(t *testing.T) {
+	oid := primitive.NewObjectID()
+	for _, id := range []documentID{{oid: oid}, {prefix: "app1", oid: oid}} {
+		raw, err := bson.Marshal(session{ID: id, Name: "key"})
+		require.Nil(t, err)
+		var doc bson.M
+		require.Nil(t, bson.Unmarshal(raw, &doc))
+		if id.prefix == "" {
+			assert.Equal(t, oid, doc["_id"])
+		} else {
+			assert.Equal(t, "app1:"+oid.Hex(), doc["_id"])
+		}
+
+		var decoded session
+		require.Nil(t, bson.Unmarshal(raw, &decoded))
+		assert.Equal(t, id, decoded.ID)
+		assert.Equal(t, oid, decoded.ObjectID())
+	}
+}
@@ -0,0 +1,171 @@
+//Package sessionstest provides an in-memory implementation of gorilla/sessions'
+//Store for unit testing handlers that use sessions_mongo.MongoDBStore without a running
+//MongoDB.
+package sessionstest
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"sync"
+)
+
+//Store is an in-memory sessions.Store.  Like MongoDBStore it keeps session values
+//server-side, gob encoded so values that couldn't be stored by MongoDBStore fail to save,
+//and only transports the session ID in the cookie.  It is safe for concurrent use.
+type Store struct {
+	mu             sync.RWMutex
+	sessions       map[string][]byte
+	codecs         []securecookie.Codec
+	defaultOptions *sessions.Options
+}
+
+//NewStore returns an empty Store.  If codecs are given, the session ID in the cookie is
+//encoded with them like MongoDBStore does; otherwise the cookie carries the plain ID.
+func NewStore(codecs ...securecookie.Codec) *Store {
+	return &Store{
+		sessions: make(map[string][]byte),
+		codecs:   codecs,
+		defaultOptions: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+	}
+}
+
+//Options replaces the default options of new sessions
+func (s *Store) Options(opts *sessions.Options) {
+	o := *opts
+	s.defaultOptions = &o
+}
+
+//Get returns a session from the request's sessions Registry
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+//New returns the session whose ID is carried by the cookie called name, or a new
+//session if there is none
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	sess := sessions.NewSession(s, name)
+	o := *s.defaultOptions
+	sess.Options = &o
+	sess.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return sess, nil
+	}
+	id, err := s.decodeID(name, cookie.Value)
+	if err != nil {
+		return sess, err
+	}
+
+	s.mu.RLock()
+	data, ok := s.sessions[id]
+	s.mu.RUnlock()
+	if !ok {
+		return sess, nil
+	}
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&sess.Values); err != nil {
+		return sess, err
+	}
+	sess.ID = id
+	sess.IsNew = false
+
+	return sess, nil
+}
+
+//Save stores sess and writes its cookie.  A negative MaxAge deletes the session and
+//clears the cookie.  As with the MongoDBStore, a nil w skips writing the cookie.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, sess *sessions.Session) error {
+	if sess.Options.MaxAge < 0 {
+		s.Delete(sess.ID)
+		setCookie(w, sessions.NewCookie(sess.Name(), "", sess.Options))
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(sess.Values); err != nil {
+		return err
+	}
+	if sess.ID == "" {
+		sess.ID = primitive.NewObjectID().Hex()
+	}
+
+	s.mu.Lock()
+	s.sessions[sess.ID] = buf.Bytes()
+	s.mu.Unlock()
+	sess.IsNew = false
+
+	encodedID, err := s.encodeID(sess.Name(), sess.ID)
+	if err != nil {
+		return err
+	}
+	setCookie(w, sessions.NewCookie(sess.Name(), encodedID, sess.Options))
+
+	return nil
+}
+
+//setCookie writes cookie to w unless w is nil
+func setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	if w == nil {
+		return
+	}
+	http.SetCookie(w, cookie)
+}
+
+//Delete removes the session with the given ID
+func (s *Store) Delete(sessionID string) {
+	s.mu.Lock()
+	delete(s.sessions, sessionID)
+	s.mu.Unlock()
+}
+
+//Values returns a copy of the values stored for the session with the given ID, to
+//assert on what a handler saved
+func (s *Store) Values(sessionID string) (map[interface{}]interface{}, bool) {
+	s.mu.RLock()
+	data, ok := s.sessions[sessionID]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	var values map[interface{}]interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return nil, false
+	}
+
+	return values, true
+}
+
+//Len returns the number of stored sessions
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.sessions)
+}
+
+func (s *Store) encodeID(name, id string) (string, error) {
+	if len(s.codecs) == 0 {
+		return id, nil
+	}
+
+	return securecookie.EncodeMulti(name, id, s.codecs...)
+}
+
+func (s *Store) decodeID(name, value string) (string, error) {
+	if len(s.codecs) == 0 {
+		return value, nil
+	}
+
+	var id string
+	err := securecookie.DecodeMulti(name, value, &id, s.codecs...)
+
+	return id, err
+}
@@ -0,0 +1,54 @@
+package sessionstest
+
+import (
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	store := NewStore(securecookie.CodecsFromPairs([]byte("abcdefghijklmnop"))...)
+	var _ sessions.Store = store
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := store.New(r, "key")
+	require.Nil(t, err)
+	assert.True(t, sess.IsNew)
+	sess.Values["user_id"] = "abc"
+	rw := httptest.NewRecorder()
+	require.Nil(t, store.Save(r, rw, sess))
+
+	values, ok := store.Values(sess.ID)
+	require.True(t, ok)
+	assert.Equal(t, "abc", values["user_id"])
+
+	r = httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	for _, cookie := range rw.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	loaded, err := store.New(r, "key")
+	require.Nil(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, "abc", loaded.Values["user_id"])
+
+	loaded.Options.MaxAge = -1
+	require.Nil(t, store.Save(r, httptest.NewRecorder(), loaded))
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestStore_Save_NilResponseWriter(t *testing.T) {
+	store := NewStore(securecookie.CodecsFromPairs([]byte("abcdefghijklmnop"))...)
+	sess, err := store.New(httptest.NewRequest(http.MethodGet, "http://example.com", nil), "key")
+	require.Nil(t, err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(t, store.Save(nil, nil, sess))
+	assert.Equal(t, 1, store.Len())
+
+	sess.Options.MaxAge = -1
+	require.Nil(t, store.Save(nil, nil, sess))
+	assert.Equal(t, 0, store.Len())
+}
@@ -0,0 +1,95 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const deletedAtIndexName = "deleted_at_1"
+
+//unsetFields lists the fields to remove whenever a session is rewritten: leftovers of
+//the legacy format and, with soft deletes, the tombstone of a previous session with the
+//same ID
+func (store *MongoDBStore) unsetFields() []string {
+	fields := store.legacyFields()
+	if store.storeOptions.SoftDelete.Enabled {
+		fields = append(fields, "deleted_at")
+	}
+
+	return fields
+}
+
+//excludeTombstones makes filter skip the tombstones kept by Options.SoftDelete
+func (store *MongoDBStore) excludeTombstones(filter bson.M) bson.M {
+	if store.storeOptions.SoftDelete.Enabled {
+		filter["deleted_at"] = bson.M{"$exists": false}
+	}
+
+	return filter
+}
+
+//softDelete marks the stored session as deleted and reports whether it existed.
+//last_modified and expires_at are removed so neither the TTL index on last_modified nor
+//DeleteExpiredSessions purges the tombstone before the deleted_at index does, and
+//values_hash so no save of unchanged values can match the tombstone.
+func (store *MongoDBStore) softDelete(ctx context.Context, oid primitive.ObjectID, name string) (bool, error) {
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	filter := store.namedFilter(oid, name)
+	filter["deleted_at"] = bson.M{"$exists": false}
+	update := bson.M{
+		"$set":   bson.M{"deleted_at": store.now()},
+		"$unset": bson.M{"last_modified": "", "expires_at": "", "values_hash": ""},
+	}
+
+	var res *mongo.UpdateResult
+	err = store.withRetry(ctx, func() error {
+		var err error
+		res, err = c.UpdateOne(ctx, filter, update)
+		return err
+	})
+	if isUnacknowledgedWrite(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount > 0, nil
+}
+
+//purgeTombstones deletes the tombstones deleted more than SoftDelete.RetainFor ago and
+//returns their number, like the deleted_at index does periodically
+func (store *MongoDBStore) purgeTombstones(ctx context.Context) (int64, error) {
+	cutoff := store.now().Add(-store.storeOptions.SoftDelete.RetainFor)
+	filter := store.scopedFilter(bson.M{"deleted_at": bson.M{"$lt": cutoff}})
+
+	res, err := store.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		_ = level.Error(store.logger).Log(
+			"message", "failed to purge deleted session tombstones",
+			"error", err,
+		)
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+func ensureDeletedAtIndex(ctx context.Context, c collection, retainFor time.Duration) error {
+	idxOpts := options.CreateIndexes().SetMaxTime(15 * time.Second)
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retainFor.Seconds())).SetName(deletedAtIndexName),
+	}, idxOpts)
+
+	return err
+}
@@ -8,30 +8,44 @@ import (
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"math/rand"
 	"net/http"
+	"regexp"
 	"time"
 )
 
 //MongoDBStore is an implementation of Gorilla/Sesions (github.com/gorilla/sessions)
 //based on the official MongoDB golang driver(https://github.com/mongodb/mongo-go-driver).
 type MongoDBStore struct {
-	collection     *mongo.Collection
+	collection     collection
 	ttl            time.Duration
 	codecs         []securecookie.Codec
 	defaultOptions *sessions.Options
 	storeOptions   Options
 	logger         log.Logger
+	background     *backgroundTasks
+	ttlIndex       *ttlIndexState
+	registry       *bsoncodec.Registry
+	decodeFailures *decodeFailureCounter
+	health         *healthState
 }
 
 //NewMongoDBStore accepts a pre-configured Collection, options for the implementation
-//as well as default options for new Sessions.  It also accepts a logger that conforms
+//as well as default options for new Sessions.  If Options sets any read preference or
+//concern, the store uses a clone of the collection configured accordingly, leaving the
+//supplied collection untouched.  It also accepts a logger that conforms
 //to the GoKit logger interface (Log(...interface{}) error).  All errors returned from Log
 //are suppressed.  The last argument is a variadic argument of implementations of
 //securecookie.Codec(https://pkg.go.dev/github.com/gorilla/securecookie#Codec)
+//
+//Nil sessionOptions default to a Path of "/" and a MaxAge of the TTL.  A MaxAge of 0 is
+//kept: sessions are still saved and expire in the datastore by TTL, but their cookies are
+//session cookies, dropped when the browser closes.  Only a negative MaxAge deletes.
 func NewMongoDBStore(
 	collection *mongo.Collection,
 	storeOptions Options,
@@ -47,22 +61,27 @@ func NewMongoDBStore(
 		logger = log.NewNopLogger()
 	}
 
-	err := ensureConnection(context.Background(), collection)
+	err := storeOptions.Validate()
 	if err != nil {
-		level.Error(logger).Log("message", "failed to create connection to mongo", "error", err)
 		return nil, err
 	}
 
-	if err = storeOptions.Validate(); err != nil {
+	//a nil collection is rejected by prepareCollection
+	if storeOptions.TTLOptions.AdoptExistingIndexTTL && !isNilCollection(collection) {
+		if storeOptions, err = adoptExistingIndexTTL(context.Background(), collection, storeOptions, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	registerJSONTypes()
+	if err = registerTypes(storeOptions.RegisterTypes); err != nil {
+		_ = level.Error(logger).Log("message", "failed to register types with gob", "error", err)
 		return nil, err
 	}
 
-	if storeOptions.TTLOptions.EnsureTTLIndex {
-		err = ensureTTLIndex(context.Background(), collection, storeOptions.TTLOptions.TTL)
-		if err != nil {
-			_ = level.Error(logger).Log("message", "failed to ensure TTL index", "error", err)
-			return nil, err
-		}
+	collection, ttlIndexEnsured, err := prepareCollection(context.Background(), collection, storeOptions, logger)
+	if err != nil {
+		return nil, err
 	}
 
 	if sessionOptions == nil {
@@ -71,17 +90,191 @@ func NewMongoDBStore(
 			MaxAge: int(storeOptions.TTLOptions.TTL.Seconds()),
 		}
 		_ = level.Debug(logger).Log("message", "nil options found, using defaults")
+	} else if sessionOptions.MaxAge == 0 {
+		_ = level.Info(logger).Log(
+			"message", "MaxAge is 0, issuing session cookies for sessions expiring after TTL",
+			"ttl", storeOptions.TTLOptions.TTL.String(),
+		)
 	}
 	_ = level.Info(logger).Log("cookie options", fmt.Sprintf("%+v", sessionOptions))
 
-	return &MongoDBStore{
+	store := &MongoDBStore{
 		collection:     collection,
 		codecs:         codecs,
 		ttl:            storeOptions.TTLOptions.TTL,
 		storeOptions:   storeOptions,
 		defaultOptions: sessionOptions,
-		logger:         logger,
-	}, nil
+		logger:         newSwappableLogger(logger),
+		background:     newBackgroundTasks(),
+		ttlIndex:       newTTLIndexState(ttlIndexEnsured),
+		registry:       newValuesRegistry(storeOptions),
+		decodeFailures: &decodeFailureCounter{},
+		health:         &healthState{},
+	}
+
+	if storeOptions.SweepOptions.Enabled {
+		store.background.every(storeOptions.SweepOptions.Interval, store.sweep)
+		_ = level.Info(logger).Log("message", "started expired session sweeper",
+			"interval", storeOptions.SweepOptions.Interval.String())
+	}
+
+	if storeOptions.HealthMonitor.Enabled {
+		store.background.every(storeOptions.HealthMonitor.Interval, store.checkHealth)
+		_ = level.Info(logger).Log("message", "started connection health monitor",
+			"interval", storeOptions.HealthMonitor.Interval.String())
+	}
+
+	return store, nil
+}
+
+//adoptExistingIndexTTL returns storeOptions with the TTL of the existing TTL index of
+//collection, see TTLOptions.AdoptExistingIndexTTL
+func adoptExistingIndexTTL(
+	ctx context.Context,
+	collection *mongo.Collection,
+	storeOptions Options,
+	logger log.Logger,
+) (Options, error) {
+	ttl, ok, err := existingIndexTTL(ctx, collection)
+	if err != nil {
+		_ = level.Error(logger).Log("message", "failed to read existing TTL index", "error", err)
+		return storeOptions, err
+	}
+	if !ok {
+		_ = level.Info(logger).Log(
+			"message", "no existing TTL index to adopt, using configured TTL",
+			"ttl", storeOptions.TTLOptions.TTL.String(),
+		)
+		return storeOptions, nil
+	}
+
+	_ = level.Info(logger).Log(
+		"message", "adopted TTL of existing TTL index",
+		"ttl", ttl.String(),
+		"configured_ttl", storeOptions.TTLOptions.TTL.String(),
+	)
+	storeOptions.TTLOptions.TTL = ttl
+
+	//options such as Jitter are bounded by the TTL
+	return storeOptions, storeOptions.Validate()
+}
+
+//isNilCollection reports whether collection is nil or isn't backed by a client
+func isNilCollection(collection *mongo.Collection) bool {
+	return collection == nil || collection.Database() == nil || collection.Database().Client() == nil
+}
+
+//prepareCollection checks the connection of collection and readies it for storing
+//sessions according to storeOptions, returning the collection the store should use and
+//whether the TTL index was created or confirmed
+func prepareCollection(
+	ctx context.Context,
+	collection *mongo.Collection,
+	storeOptions Options,
+	logger log.Logger,
+) (*mongo.Collection, bool, error) {
+	if isNilCollection(collection) {
+		_ = level.Error(logger).Log("message", "cannot use collection", "error", ErrNilCollection)
+		return nil, false, ErrNilCollection
+	}
+
+	err := ensureConnection(ctx, collection, storeOptions.RequirePrimaryOnConnect)
+	if err != nil {
+		level.Error(logger).Log("message", "failed to create connection to mongo", "error", err)
+		return nil, false, err
+	}
+
+	if collectionOpts := storeOptions.collectionOptions(); collectionOpts != nil {
+		if collection, err = collection.Clone(collectionOpts); err != nil {
+			_ = level.Error(logger).Log("message", "failed to clone collection", "error", err)
+			return nil, false, err
+		}
+	}
+
+	if storeOptions.CappedOptions.Enabled {
+		if err = ensureCappedCollection(ctx, collection, storeOptions.CappedOptions); err != nil {
+			_ = level.Error(logger).Log("message", "failed to ensure capped collection", "error", err)
+			return nil, false, err
+		}
+	}
+
+	if storeOptions.SkipIndexCreation {
+		_ = level.Info(logger).Log("message", "skipping index creation")
+		return collection, false, nil
+	}
+
+	ttlIndexEnsured, err := ensureConfiguredTTLIndex(ctx, collection.Indexes(), storeOptions.TTLOptions, logger)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if storeOptions.SoftDelete.Enabled {
+		if err = ensureDeletedAtIndex(ctx, collection, storeOptions.SoftDelete.RetainFor); err != nil {
+			_ = level.Error(logger).Log("message", "failed to ensure deleted_at TTL index", "error", err)
+			return nil, false, err
+		}
+	}
+
+	err = ensureAdditionalIndexes(ctx, collection, storeOptions.AdditionalIndexes, logger)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return collection, ttlIndexEnsured, nil
+}
+
+//ensureConfiguredTTLIndex creates the TTL index through indexes if ttlOptions ask for
+//it, reporting whether the index was created or confirmed.  An authorization error
+//tolerated by IndexBestEffort reports false, as the index may well be missing.
+func ensureConfiguredTTLIndex(
+	ctx context.Context,
+	indexes indexCreator,
+	ttlOptions TTLOptions,
+	logger log.Logger,
+) (bool, error) {
+	if !ttlOptions.EnsureTTLIndex {
+		return false, nil
+	}
+
+	err := ensureTTLIndex(ctx, indexes, ttlOptions.TTL)
+	if isIndexConflictError(err) {
+		_ = level.Error(logger).Log(
+			"message", "existing TTL index has different expireAfterSeconds; call RebuildTTLIndex or set a matching TTL",
+			"ttl_seconds", int(ttlOptions.TTL.Seconds()),
+			"error", err,
+		)
+		return false, ErrTTLIndexConflict
+	}
+	if err != nil && ttlOptions.IndexBestEffort && isUnauthorizedError(err) {
+		_ = level.Warn(logger).Log(
+			"message", "not authorized to create TTL index, assuming it exists",
+			"error", err,
+		)
+		return false, nil
+	}
+	if err != nil {
+		_ = level.Error(logger).Log("message", "failed to ensure TTL index", "error", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+//TTLIndexEnsured reports whether the store created or confirmed the TTL index on
+//last_modified, either during construction or through RebuildTTLIndex.  A false result
+//means expiry relies on an index the store has not verified.
+func (store *MongoDBStore) TTLIndexEnsured() bool {
+	return store.ttlIndex.isEnsured()
+}
+
+//Close stops any background goroutines started by the store and waits for them to
+//return.  It does not disconnect the underlying client.  It is safe to call more than once.
+func (store *MongoDBStore) Close() error {
+	if store.background != nil {
+		store.background.close()
+	}
+
+	return nil
 }
 
 //Get creates or retrieves a session based on a cookie attached to a request with the
@@ -92,125 +285,710 @@ func (store *MongoDBStore) Get(r *http.Request, name string) (*sessions.Session,
 }
 
 //Save gob encodes sess.Values and optionally encrypts the data depending on codec.  The resulting value
-//is then stored under sess.ID in the backing datastore.
+//is then stored under sess.ID in the backing datastore.  If w is nil the session is persisted
+//without writing a cookie; EncodedID returns the value the cookie would have carried.
+//Following gorilla/sessions, a negative MaxAge deletes the stored session and clears the
+//cookie, unless Options.KeepCookieOnDeleteError keeps it after a failed deletion, while a
+//MaxAge of 0 persists the session, for the store's TTL, behind a session
+//cookie that expires when the browser is closed.
 func (store *MongoDBStore) Save(r *http.Request, w http.ResponseWriter, sess *sessions.Session) error {
+	logger := store.contextLogger(r.Context())
 	var err error
-	if sess.Options.MaxAge <= 0 {
-		return store.clearSession(r.Context(), w, sess)
+	cookieOpts := store.cookieOptions(r, sess)
+	saves := store.requestSaves(r)
+	if sess.Options.MaxAge < 0 {
+		saves.forget(sess)
+		store.clearLegacyCookies(r, w, cookieOpts)
+		return store.clearSession(r.Context(), w, sess, cookieOpts)
 	}
 
 	if sess.ID == "" {
 		sess.ID = primitive.NewObjectID().Hex()
 	}
 
-	if err = store.save(r.Context(), sess); err != nil {
-		return err
+	var hash string
+	if saves != nil {
+		hash = store.contentHash(sess)
+	}
+	if writeConcernOverride(r.Context()) != nil || !saves.unchanged(sess, hash) {
+		if err = store.save(store.withFingerprint(r.Context(), r), sess); err != nil {
+			return err
+		}
+		saves.record(sess, hash)
 	}
 	sess.IsNew = false
 
-	encodedID, err := securecookie.EncodeMulti(sess.Name(), sess.ID, store.codecs...)
+	if w == nil {
+		return nil
+	}
+
+	encodedID, err := store.EncodedID(sess)
 	if err != nil {
-		_ = level.Error(store.logger).Log(
+		_ = level.Error(logger).Log(
 			"message", "failed to encode session ID",
 			"sessionID", sess.ID,
 			"error", err,
 		)
 		return err
 	}
-	http.SetCookie(w, sessions.NewCookie(sess.Name(), encodedID, sess.Options))
+	store.writeID(w, sess.Name(), encodedID, cookieOpts)
+	store.clearLegacyCookies(r, w, cookieOpts)
 
 	return nil
 }
 
-func (store *MongoDBStore) clearSession(ctx context.Context, w http.ResponseWriter, sess *sessions.Session) error {
-	if !sess.IsNew {
-		if err := store.delete(ctx, sess.ID); err != nil {
-			_ = level.Info(store.logger).Log(
+//EncodedID returns the session ID of sess encoded with the store's IDCodec, i.e. the
+//value of the cookie written by Save.
+func (store *MongoDBStore) EncodedID(sess *sessions.Session) (string, error) {
+	return store.idCodec().Encode(sess.Name(), sess.ID)
+}
+
+//Codecs returns a copy of the securecookie codecs the store was created with, e.g. to
+//sign a value bound to the session with securecookie.EncodeMulti.  Modifying the
+//returned slice doesn't affect the store.
+func (store *MongoDBStore) Codecs() []securecookie.Codec {
+	return append([]securecookie.Codec(nil), store.codecs...)
+}
+
+//idCodec returns Options.IDCodec, defaulting to the store's securecookie codecs
+func (store *MongoDBStore) idCodec() IDCodec {
+	if store.storeOptions.IDCodec != nil {
+		return store.storeOptions.IDCodec
+	}
+
+	return NewSecureCookieIDCodec(store.codecs...)
+}
+
+//cookieOptions returns the options used for the cookie of sess: sess.Options, or the
+//SaveOptions.CookieOptions of the save.  Without an Options.CookieOptionsHook these are
+//used verbatim; otherwise the hook adjusts a copy, leaving them untouched.
+func (store *MongoDBStore) cookieOptions(r *http.Request, sess *sessions.Session) *sessions.Options {
+	base := sess.Options
+	if override, ok := r.Context().Value(cookieOptionsKey{}).(*sessions.Options); ok {
+		base = override
+	}
+	if store.storeOptions.CookieOptionsHook == nil {
+		return base
+	}
+
+	opts := derefOpts(base)
+	store.storeOptions.CookieOptionsHook(r, opts)
+	return opts
+}
+
+//setCookie writes cookie to w, doing nothing when there is no writer
+func setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	if w != nil {
+		http.SetCookie(w, cookie)
+	}
+}
+
+//setPartitionedCookie writes cookie to w with the Partitioned attribute, which neither
+//sessions.Options nor http.Cookie of older Go versions carry
+func setPartitionedCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	if w == nil {
+		return
+	}
+	if v := cookie.String(); v != "" {
+		w.Header().Add("Set-Cookie", v+"; Partitioned")
+	}
+}
+
+//writeID transports the encoded session ID of the session called name to the client, in
+//a cookie or, with Options.CookieDisabled, in the Options.HeaderName response header.  An
+//empty encodedID clears it.
+func (store *MongoDBStore) writeID(w http.ResponseWriter, name, encodedID string, cookieOpts *sessions.Options) {
+	if !store.storeOptions.CookieDisabled {
+		cookie := sessions.NewCookie(name, encodedID, cookieOpts)
+		if store.storeOptions.PartitionedCookies {
+			setPartitionedCookie(w, cookie)
+		} else {
+			setCookie(w, cookie)
+		}
+		return
+	}
+
+	if w != nil && store.storeOptions.HeaderName != "" {
+		w.Header().Set(store.storeOptions.HeaderName, encodedID)
+	}
+}
+
+//readID returns the encoded session ID of the session called name sent by the client
+func (store *MongoDBStore) readID(r *http.Request, name string) (string, bool) {
+	if store.storeOptions.CookieDisabled {
+		if store.storeOptions.HeaderName == "" {
+			return "", false
+		}
+		encodedID := r.Header.Get(store.storeOptions.HeaderName)
+		return encodedID, encodedID != ""
+	}
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+
+	return cookie.Value, true
+}
+
+func (store *MongoDBStore) legacyCookieNames() []string {
+	if store.storeOptions.CookieDisabled {
+		return nil
+	}
+
+	return store.storeOptions.LegacyCookieNames
+}
+
+//readSessionID returns the name and value of the cookie, or header, carrying the
+//encoded ID of the session called name, falling back to Options.LegacyCookieNames
+func (store *MongoDBStore) readSessionID(r *http.Request, name string) (string, string, bool) {
+	if encodedID, ok := store.readID(r, name); ok {
+		return name, encodedID, true
+	}
+
+	return store.readLegacyID(r)
+}
+
+//readLegacyID returns the name and value of the first Options.LegacyCookieNames cookie
+//sent by the client
+func (store *MongoDBStore) readLegacyID(r *http.Request) (string, string, bool) {
+	for _, name := range store.legacyCookieNames() {
+		if encodedID, ok := store.readID(r, name); ok {
+			return name, encodedID, true
+		}
+	}
+
+	return "", "", false
+}
+
+//clearLegacyCookies expires the Options.LegacyCookieNames cookies sent by the client
+func (store *MongoDBStore) clearLegacyCookies(r *http.Request, w http.ResponseWriter, cookieOpts *sessions.Options) {
+	for _, name := range store.legacyCookieNames() {
+		if _, ok := store.readID(r, name); ok {
+			setCookie(w, sessions.NewCookie(name, "", &sessions.Options{
+				Path:     cookieOpts.Path,
+				Domain:   cookieOpts.Domain,
+				MaxAge:   -1,
+				Secure:   cookieOpts.Secure,
+				HttpOnly: cookieOpts.HttpOnly,
+				SameSite: cookieOpts.SameSite,
+			}))
+		}
+	}
+}
+
+//clearSession deletes the stored session, if there can be one, and always clears the cookie.
+//Sessions that are new, have no valid ID or are already absent from the datastore are not errors.
+func (store *MongoDBStore) clearSession(
+	ctx context.Context,
+	w http.ResponseWriter,
+	sess *sessions.Session,
+	cookieOpts *sessions.Options,
+) error {
+	logger := store.contextLogger(ctx)
+	if !sess.IsNew && isValidSessionID(sess.ID) {
+		deleted, err := store.deleteNamed(ctx, sess.ID, sess.Name())
+		if err != nil {
+			_ = level.Info(logger).Log(
 				"message", "failed to delete session ID",
 				"sessionID", sess.ID,
 				"error", err,
 			)
-			http.SetCookie(w, sessions.NewCookie(sess.Name(), "", sess.Options))
+			if !store.storeOptions.KeepCookieOnDeleteError {
+				store.writeID(w, sess.Name(), "", cookieOpts)
+			}
 			return err
 		}
+		_ = level.Debug(logger).Log(
+			"message", "cleared session",
+			"sessionID", sess.ID,
+			"deleted", deleted,
+		)
+		if deleted {
+			recordSaveResult(ctx, SaveDeleted)
+		}
 	}
 
-	http.SetCookie(w, sessions.NewCookie(sess.Name(), "", sess.Options))
+	store.writeID(w, sess.Name(), "", cookieOpts)
 	return nil
 }
 
 func (store *MongoDBStore) save(ctx context.Context, sess *sessions.Session) error {
-	s, err := sessionFromGorillaSession(sess, store.codecs...)
+	logger := store.contextLogger(ctx)
+	if store.storeOptions.Layout == LayoutSubdocument {
+		return store.saveSubdocument(ctx, sess)
+	}
+
+	//a failed write may still have been applied, so the cached copy is dropped either way
+	defer store.uncache(sess.ID)
+
+	var valuesHash string
+	if store.storeOptions.SkipUnchangedWrites {
+		var err error
+		if valuesHash, err = hashValues(sess.Values); err != nil {
+			_ = level.Error(logger).Log(
+				"message", "failed to hash session values",
+				"error", err,
+			)
+			return err
+		}
+
+		if !sess.IsNew {
+			touched, err := store.touchIfUnchanged(ctx, sess, valuesHash)
+			if err != nil {
+				return err
+			}
+			if touched {
+				recordSaveResult(ctx, SaveTouched)
+				return nil
+			}
+		}
+	}
+
+	s, err := store.toDocument(sess)
 	if err != nil {
-		_ = level.Error(store.logger).Log(
+		_ = level.Error(logger).Log(
 			"message", "failed to transform session",
 			"error", err,
 		)
 		return err
 	}
+	s.ValuesHash = valuesHash
+	s.Fingerprint = fingerprintFromContext(ctx)
+
+	if store.storeOptions.WriteMode == WriteModeStrict {
+		if sess.IsNew {
+			return store.insertSession(ctx, s)
+		}
+		return store.updateSession(ctx, s)
+	}
 
 	return store.saveSession(ctx, s)
 }
 
+func (store *MongoDBStore) toDocument(sess *sessions.Session) (session, error) {
+	var s session
+	var err error
+	if store.storeOptions.Serialization == SerializationBSON {
+		s, err = bsonSessionFromGorillaSession(sess, store.valuesRegistry())
+	} else {
+		s, err = sessionFromGorillaSession(sess, store.storeOptions.ValueSerializer, store.codecs...)
+	}
+	if err != nil {
+		return session{}, err
+	}
+
+	s.ID = store.documentID(s.ObjectID())
+	s.Name = sess.Name()
+	s.Format = store.storeOptions.Serialization.marker()
+	s.LastModified = store.now()
+	s.CreatedAt = s.LastModified
+	s.ExpiresAt = store.expiresAt(sess.Name(), s.LastModified)
+
+	fields := store.sessionFields(sess.ID)
+	if store.storeOptions.ExtraFields != nil {
+		for k, v := range store.storeOptions.ExtraFields(sess) {
+			if _, scoped := fields[k]; scoped || isReservedField(k) {
+				return session{}, NewReservedFieldErr(k)
+			}
+			fields[k] = v
+		}
+	}
+	if len(fields) > 0 {
+		s.Extra = fields
+	}
+
+	return s, nil
+}
+
+//decodeValues populates sess.Values from whichever representation the stored
+//document carries, so documents written in either format can be loaded.
+func (store *MongoDBStore) decodeValues(sess *sessions.Session, s session) error {
+	if len(s.Values) > 0 {
+		values, err := unmarshalBSONValues(s.Values, store.valuesRegistry(), store.storeOptions.ValueTypes)
+		if err != nil {
+			return err
+		}
+		sess.Values = values
+		return nil
+	}
+
+	if store.isLegacyDocument(s) {
+		return store.decodeLegacyValues(sess, s)
+	}
+
+	values, err := store.DecodeValues(sess.Name(), s.Data)
+	if err == nil {
+		sess.Values = values
+		return nil
+	}
+	//the data of a session found through a legacy cookie is bound to the old name until
+	//the session is next rewritten
+	for _, name := range store.legacyCookieNames() {
+		if values, legacyErr := store.DecodeValues(name, s.Data); legacyErr == nil {
+			sess.Values = values
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (store *MongoDBStore) valuesRegistry() *bsoncodec.Registry {
+	if store.storeOptions.Registry != nil {
+		return store.storeOptions.Registry
+	}
+	if store.registry != nil {
+		return store.registry
+	}
+
+	return defaultValuesRegistry
+}
+
+//touchUpdate refreshes last_modified and expires_at of a session named name
+func (store *MongoDBStore) touchUpdate(name string) bson.M {
+	now := store.now()
+	return bson.M{"$set": bson.M{
+		"last_modified": now,
+		"expires_at":    store.expiresAt(name, now),
+	}}
+}
+
+//now returns the current time per Options.Clock
+func (store *MongoDBStore) now() time.Time {
+	if store.storeOptions.Clock != nil {
+		return store.storeOptions.Clock()
+	}
+
+	return currentTime()
+}
+
+//expiresAt returns the expiry of a session named name modified at lastModified,
+//randomized by TTLOptions.Jitter
+func (store *MongoDBStore) expiresAt(name string, lastModified time.Time) time.Time {
+	expiresAt := lastModified.Add(store.ttlFor(name))
+	if jitter := store.storeOptions.TTLOptions.Jitter; jitter > 0 {
+		expiresAt = expiresAt.Add(time.Duration(rand.Int63n(int64(2*jitter)+1)) - jitter)
+	}
+
+	return expiresAt
+}
+
+//ttlFor returns the TTL of sessions named name, per Options.TTLOptions.TTLByName
+func (store *MongoDBStore) ttlFor(name string) time.Duration {
+	if ttl, ok := store.storeOptions.TTLOptions.TTLByName[name]; ok {
+		return ttl
+	}
+
+	return store.ttl
+}
+
+//touchIfUnchanged refreshes last_modified on the stored session only if its stored
+//values hash matches valuesHash.  It reports whether a document was matched.
+func (store *MongoDBStore) touchIfUnchanged(ctx context.Context, sess *sessions.Session, valuesHash string) (bool, error) {
+	logger := store.contextLogger(ctx)
+	sessionID := sess.ID
+	oid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var res *mongo.UpdateResult
+	err = store.withRetry(ctx, func() error {
+		res, err = c.UpdateOne(
+			ctx,
+			store.touchFilter(oid, sess.Name(), valuesHash),
+			store.touchUpdate(sess.Name()),
+		)
+		return err
+	})
+	if isUnacknowledgedWrite(err) {
+		//whether the touch matched is unknown, so the session is written in full
+		return false, nil
+	}
+	if err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to touch unchanged session",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return false, err
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
 func (store *MongoDBStore) saveSession(ctx context.Context, sess session) error {
+	if store.storeOptions.CappedOptions.Enabled {
+		return store.replaceCappedSession(ctx, sess, false)
+	}
+
+	logger := store.contextLogger(ctx)
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return err
+	}
+
+	var res *mongo.UpdateResult
 	opts := options.Update().SetUpsert(true)
-	update := updateDocFromSession(sess)
-	_, err := store.collection.UpdateOne(ctx, bson.M{"_id": sess.ID}, update, opts)
+	update := updateDocFromSession(sess, store.unsetFields()...)
+	err = store.withRetry(ctx, func() error {
+		var err error
+		res, err = c.UpdateOne(ctx, store.namedFilter(sess.ObjectID(), sess.Name), update, opts)
+		return err
+	})
+	if isUnacknowledgedWrite(err) {
+		return nil
+	}
 	if err != nil {
-		_ = level.Error(store.logger).Log(
+		_ = level.Error(logger).Log(
 			"message", "failed to save session in database",
 			"session_id", sess.ID.String(),
 			"error", err,
 		)
 		return err
 	}
+	recordSaveResult(ctx, upsertResult(res))
 
 	return nil
 }
-func (store *MongoDBStore) delete(ctx context.Context, sessionID string) error {
-	oid, err := primitive.ObjectIDFromHex(sessionID)
+
+func (store *MongoDBStore) insertSession(ctx context.Context, sess session) error {
+	logger := store.contextLogger(ctx)
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.InsertOne(ctx, sess)
+	if isUnacknowledgedWrite(err) {
+		return nil
+	}
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			err = NewDuplicateSessionIDErr(sess.ID.Hex(), err)
+		}
+		_ = level.Error(logger).Log(
+			"message", "failed to insert session in database",
+			"session_id", sess.ID.String(),
+			"error", err,
+		)
+		return err
+	}
+	recordSaveResult(ctx, SaveCreated)
+
+	return nil
+}
+
+func (store *MongoDBStore) updateSession(ctx context.Context, sess session) error {
+	if store.storeOptions.CappedOptions.Enabled {
+		return store.replaceCappedSession(ctx, sess, true)
+	}
+
+	logger := store.contextLogger(ctx)
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return err
+	}
+
+	var res *mongo.UpdateResult
+	update := updateDocFromSession(sess, store.unsetFields()...)
+	err = store.withRetry(ctx, func() error {
+		var err error
+		res, err = c.UpdateOne(ctx, store.namedFilter(sess.ObjectID(), sess.Name), update)
+		return err
+	})
+	if isUnacknowledgedWrite(err) {
+		return nil
+	}
 	if err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to update session in database",
+			"session_id", sess.ID.String(),
+			"error", err,
+		)
 		return err
 	}
 
-	return store.collection.FindOneAndDelete(ctx, bson.M{"_id": oid}).Err()
+	if res.MatchedCount == 0 {
+		_ = level.Info(logger).Log(
+			"message", "session to update no longer exists",
+			"session_id", sess.ID.String(),
+		)
+		return ErrSessionNotFound
+	}
+	recordSaveResult(ctx, SaveUpdated)
+
+	return nil
+}
+
+//upsertResult tells apart the upsert of a session inserting its document from the one
+//updating it
+func upsertResult(res *mongo.UpdateResult) SaveResult {
+	if res.UpsertedCount > 0 || res.UpsertedID != nil {
+		return SaveCreated
+	}
+
+	return SaveUpdated
+}
+
+//Delete removes the stored session with the given ID and reports whether a session was
+//actually removed, as opposed to already being absent.  No cookie is cleared; use Save with
+//a negative MaxAge for that.  A malformed sessionID returns an *InvalidSessionIDErr.
+func (store *MongoDBStore) Delete(ctx context.Context, sessionID string) (bool, error) {
+	if _, err := parseSessionID(sessionID); err != nil {
+		return false, err
+	}
+
+	deleted, err := store.delete(ctx, sessionID, "")
+	if err != nil {
+		_ = level.Error(store.contextLogger(ctx)).Log(
+			"message", "failed to delete session",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return false, err
+	}
+
+	return deleted, nil
+}
+
+//delete removes the stored session, if it is called name or name is empty, and reports
+//whether it existed
+func (store *MongoDBStore) delete(ctx context.Context, sessionID, name string) (bool, error) {
+	oid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	if store.storeOptions.SoftDelete.Enabled {
+		deleted, err := store.softDelete(ctx, oid, name)
+		store.uncache(sessionID)
+		if deleted {
+			store.invalidated(sessionID)
+		}
+		return deleted, err
+	}
+
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	opts := options.FindOneAndDelete().SetProjection(bson.M{"_id": 1})
+	err = store.withRetry(ctx, func() error {
+		return c.FindOneAndDelete(ctx, store.namedFilter(oid, name), opts).Err()
+	})
+	store.uncache(sessionID)
+	if err == mongo.ErrNoDocuments || isUnacknowledgedWrite(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	store.invalidated(sessionID)
+
+	return true, nil
 }
 
 //New creates a new Session with default Session options defined during MongoDBStore instantiation.
 //If a cookie exists with the key `sessionKey`, New will attempt to load a session from the datastore based
 //on the decoded cookie value.  Per gorilla/sessions, New will always return at least a new usable session,
-//along with any accompanying error.
+//along with any accompanying error.  A cookie that fails to decode, e.g. because it was tampered
+//with or signed by a rotated-out key, yields a fresh session alongside the decode error.
+//Without a cookie, calling New again for the same name during the same request returns
+//the same fresh session rather than one with another ID; nothing is stored until Save.
 func (store *MongoDBStore) New(r *http.Request, sessionKey string) (*sessions.Session, error) {
 	sess := sessions.NewSession(store, sessionKey)
 	sess.ID = primitive.NewObjectID().Hex()
 	sess.Options = derefOpts(store.defaultOptions)
 	sess.IsNew = true
 
-	var cookie *http.Cookie
-	var err error
-
-	if cookie, err = r.Cookie(sessionKey); err != nil {
+	cookieName, encodedID, ok := store.readSessionID(r, sessionKey)
+	if !ok {
+		if fresh, created := freshSession(r, sessionKey); created {
+			return fresh, nil
+		}
+		rememberFreshSession(r, sess)
 		return sess, nil
 	}
 
-	err = securecookie.DecodeMulti(sessionKey, cookie.Value, &sess.ID, store.codecs...)
+	decodedID, err := store.idCodec().Decode(cookieName, encodedID)
 	if err != nil {
+		if mismatch := store.cookieNameMismatch(r, cookieName, encodedID); mismatch != nil {
+			err = mismatch
+		}
+		_ = level.Debug(store.contextLogger(r.Context())).Log(
+			"message", "failed to decode session cookie, starting a fresh session",
+			"error", err,
+		)
 		return sess, err
 	}
+	if decodedID == "" {
+		_ = level.Debug(store.contextLogger(r.Context())).Log(
+			"message", "session cookie decoded to an empty session ID, starting a fresh session",
+		)
+		return sess, nil
+	}
+	sess.ID = decodedID
 
 	err = store.load(r.Context(), sess)
+	if err == errSessionReset {
+		return sess, nil
+	}
 	if err != nil {
 		return sess, err
 	}
 	sess.IsNew = false
+	store.refreshLoaded(r, sess)
+
+	return sess, nil
+}
+
+//NewWithID creates a session named `name` with the given, server-issued ID rather than
+//generating one or reading it from a cookie, e.g. for sessions pre-provisioned by an auth
+//service.  If a session is already stored under id it is loaded.  The ID must be a hex
+//encoded ObjectID, otherwise an *InvalidSessionIDErr is returned.  Unlike Get, the
+//session is not cached in the sessions Registry.  With DecodeErrorReset, an undecodable
+//session stored under id is deleted and a fresh session keeping id is returned.
+func (store *MongoDBStore) NewWithID(r *http.Request, name, id string) (*sessions.Session, error) {
+	if _, err := parseSessionID(id); err != nil {
+		return nil, err
+	}
+
+	sess := sessions.NewSession(store, name)
+	sess.ID = id
+	sess.Options = derefOpts(store.defaultOptions)
+	sess.IsNew = true
+
+	err := store.load(r.Context(), sess)
+	if err == errSessionReset {
+		//the ID is the caller's, not one read from a cookie, so it is kept
+		sess.ID = id
+		return sess, nil
+	}
+	if err == mongo.ErrNoDocuments {
+		return sess, nil
+	}
+	if err != nil {
+		return sess, err
+	}
+	sess.IsNew = false
+	store.refreshLoaded(r, sess)
 
 	return sess, nil
 }
 
 func (store *MongoDBStore) load(ctx context.Context, sess *sessions.Session) error {
+	logger := store.contextLogger(ctx)
 	oid, err := primitive.ObjectIDFromHex(sess.ID)
 	if err != nil {
-		_ = level.Debug(store.logger).Log(
+		_ = level.Debug(logger).Log(
 			"message", "invalid sessionID, must be BSON ID",
 			"session_id", sess.ID,
 			"error", err,
@@ -218,9 +996,19 @@ func (store *MongoDBStore) load(ctx context.Context, sess *sessions.Session) err
 		return err
 	}
 
-	var s session
-	if err = store.collection.FindOne(ctx, bson.M{"_id": oid}).Decode(&s); err != nil {
-		_ = level.Error(store.logger).Log(
+	s, err := store.findNamedSession(ctx, oid, sess.Name())
+	if err != nil && err != mongo.ErrNoDocuments {
+		if stale, ok := store.staleSession(sess.ID); ok && store.matchesName(stale, sess.Name()) {
+			_ = level.Warn(logger).Log(
+				"message", "serving cached session, failed to load it from the collection",
+				"session_id", sess.ID,
+				"error", err,
+			)
+			s, err = stale, nil
+		}
+	}
+	if err != nil {
+		_ = level.Error(logger).Log(
 			"message", "failed to load allegedly existing session",
 			"session_id", sess.ID,
 			"error", err,
@@ -228,21 +1016,115 @@ func (store *MongoDBStore) load(ctx context.Context, sess *sessions.Session) err
 		return err
 	}
 
-	if err = securecookie.DecodeMulti(sess.Name(), s.Data,
-		&sess.Values, store.codecs...); err != nil {
+	if !s.DeletedAt.IsZero() {
+		_ = level.Debug(logger).Log(
+			"message", "stored session was deleted",
+			"session_id", sess.ID,
+			"deleted_at", s.DeletedAt,
+		)
+		return mongo.ErrNoDocuments
+	}
+
+	if store.storeOptions.TTLOptions.EnforceOnRead && store.isExpired(s, sess.Name()) {
+		_ = level.Debug(logger).Log(
+			"message", "stored session has expired",
+			"session_id", sess.ID,
+			"last_modified", s.LastModified,
+		)
+		return mongo.ErrNoDocuments
+	}
+
+	if err = store.decodeValues(sess, s); err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to decode stored session values",
+			"session_id", sess.ID,
+			"error", err,
+		)
+		store.decodeFailed(sess.ID, err)
+		if store.storeOptions.OnDecodeError == DecodeErrorReset {
+			store.discardUndecodableSession(ctx, sess)
+			return errSessionReset
+		}
 		return err
 	}
 
 	return nil
 }
 
-func ensureConnection(ctx context.Context, c *mongo.Collection) error {
-	return c.Database().Client().Ping(ctx, readpref.PrimaryPreferred())
+//isExpired reports whether s, a session named name, is past its expiry plus
+//TTLOptions.GracePeriod.  Documents written before expires_at was recorded expire TTL
+//after their last modification, and documents without either time never expire.
+func (store *MongoDBStore) isExpired(s session, name string) bool {
+	expiresAt := s.ExpiresAt
+	if expiresAt.IsZero() {
+		if s.LastModified.IsZero() {
+			return false
+		}
+		expiresAt = s.LastModified.Add(store.ttlFor(name))
+	}
+
+	ttlOptions := store.storeOptions.TTLOptions
+	return expiresAt.Add(ttlOptions.GracePeriod + ttlOptions.ClockSkew).Before(store.now())
+}
+
+func (store *MongoDBStore) findSession(ctx context.Context, oid primitive.ObjectID, name string) (session, error) {
+	if s, ok := store.cachedSession(oid.Hex()); ok && store.matchesName(s, name) {
+		return s, nil
+	}
+
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return session{}, err
+	}
+
+	var s session
+	opts := options.FindOne()
+	if store.storeOptions.LoadProjection != nil {
+		opts.SetProjection(store.storeOptions.LoadProjection)
+	}
+	err = store.withRetry(ctx, func() error {
+		return c.FindOne(ctx, store.namedFilter(oid, name), opts).Decode(&s)
+	})
+	if err == nil {
+		store.cacheSession(s)
+	}
+
+	return s, err
+}
+
+//discardUndecodableSession deletes a stored session that cannot be decoded and resets
+//sess to a fresh session, so the corrupt document cannot wedge its owner.
+func (store *MongoDBStore) discardUndecodableSession(ctx context.Context, sess *sessions.Session) {
+	if _, err := store.deleteNamed(ctx, sess.ID, sess.Name()); err != nil {
+		_ = level.Error(store.contextLogger(ctx)).Log(
+			"message", "failed to delete undecodable session",
+			"session_id", sess.ID,
+			"error", err,
+		)
+	}
+
+	sess.ID = primitive.NewObjectID().Hex()
+	sess.Values = make(map[interface{}]interface{})
+	sess.IsNew = true
+}
+
+//ensureConnection pings the deployment, only accepting a primary if requirePrimary is set
+func ensureConnection(ctx context.Context, c *mongo.Collection, requirePrimary bool) error {
+	return c.Database().Client().Ping(ctx, pingReadPref(requirePrimary))
+}
+
+//pingReadPref returns the read preference connection checks ping with
+func pingReadPref(requirePrimary bool) *readpref.ReadPref {
+	if requirePrimary {
+		return readpref.Primary()
+	}
+
+	return readpref.PrimaryPreferred()
 }
 
-func ensureTTLIndex(ctx context.Context, collection *mongo.Collection, ttl time.Duration) error {
+func ensureTTLIndex(ctx context.Context, indexes indexCreator, ttl time.Duration) error {
 	idxOpts := options.CreateIndexes().SetMaxTime(15 * time.Second)
-	_, err := collection.Indexes().CreateOne(ctx, makeTTLIndexModel(ttl), idxOpts)
+	_, err := indexes.CreateOne(ctx, makeTTLIndexModel(ttl), idxOpts)
 	if err != nil {
 		return err
 	}
@@ -250,16 +1132,166 @@ func ensureTTLIndex(ctx context.Context, collection *mongo.Collection, ttl time.
 	return nil
 }
 
+//idFilter matches the session with the given ID within the scope of Options.BaseFilter
+//and Options.IDPrefix, targeting its shard when Options.ShardKeyFunc is set
+func (store *MongoDBStore) idFilter(oid primitive.ObjectID) bson.M {
+	filter := store.sessionFields(oid.Hex())
+	filter["_id"] = store.documentID(oid)
+	return filter
+}
+
+//documentID is the _id of the document of the session with the given ID
+func (store *MongoDBStore) documentID(oid primitive.ObjectID) documentID {
+	return documentID{prefix: store.storeOptions.IDPrefix, oid: oid}
+}
+
+//sessionFields are the fields, other than _id, that both identify the session with the
+//given ID in filters and are written to its document
+func (store *MongoDBStore) sessionFields(sessionID string) bson.M {
+	fields := bson.M{}
+	if store.storeOptions.ShardKeyFunc != nil {
+		for k, v := range store.storeOptions.ShardKeyFunc(sessionID) {
+			fields[k] = v
+		}
+	}
+	for k, v := range store.storeOptions.BaseFilter {
+		fields[k] = v
+	}
+
+	return fields
+}
+
+//namedFilter is idFilter restricted to the session called name, so an ID can never be
+//loaded, overwritten or deleted under another session's name.  Documents stored before
+//the name was recorded, or under one of Options.LegacyCookieNames, match as well until
+//they are next saved.  Sub-documents are already scoped by their path, and an empty name
+//matches any session.
+func (store *MongoDBStore) namedFilter(oid primitive.ObjectID, name string) bson.M {
+	return store.scopeToName(store.idFilter(oid), name)
+}
+
+//scopeToName makes filter match only sessions namedFilter would match for name
+func (store *MongoDBStore) scopeToName(filter bson.M, name string) bson.M {
+	if name != "" && store.storeOptions.Layout != LayoutSubdocument {
+		names := bson.A{name, nil}
+		for _, legacyName := range store.legacyCookieNames() {
+			names = append(names, legacyName)
+		}
+		filter["name"] = bson.M{"$in": names}
+	}
+	return filter
+}
+
+//matchesName reports whether namedFilter would match s for name
+func (store *MongoDBStore) matchesName(s session, name string) bool {
+	if name == "" || s.Name == "" || s.Name == name || store.storeOptions.Layout == LayoutSubdocument {
+		return true
+	}
+	for _, legacyName := range store.legacyCookieNames() {
+		if s.Name == legacyName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (store *MongoDBStore) touchFilter(oid primitive.ObjectID, name, valuesHash string) bson.M {
+	filter := store.excludeTombstones(store.namedFilter(oid, name))
+	filter["values_hash"] = valuesHash
+	return filter
+}
+
+//scopedFilter adds the fields of Options.BaseFilter to filter and restricts it to the _ids
+//of Options.IDPrefix.  BaseFilter fields take precedence so a caller supplied filter can
+//never widen the store's scope.
+func (store *MongoDBStore) scopedFilter(filter bson.M) bson.M {
+	for k, v := range store.storeOptions.BaseFilter {
+		filter[k] = v
+	}
+	if store.storeOptions.IDPrefix != "" {
+		addToIDFilter(filter, idPrefixFilter(store.storeOptions.IDPrefix))
+	}
+
+	return filter
+}
+
+//idPrefixFilter matches the string _ids of the sessions saved with the given
+//Options.IDPrefix.  The anchored expression is answered from the _id index.
+func idPrefixFilter(prefix string) bson.M {
+	return bson.M{"$regex": "^" + regexp.QuoteMeta(prefix+idPrefixSeparator)}
+}
+
+//addToIDFilter adds the operators of condition to the _id condition of filter
+func addToIDFilter(filter bson.M, condition bson.M) {
+	existing, ok := filter["_id"].(bson.M)
+	if !ok {
+		filter["_id"] = condition
+		return
+	}
+	for k, v := range condition {
+		existing[k] = v
+	}
+}
+
 func derefOpts(opts *sessions.Options) *sessions.Options {
 	o := *opts
 	return &o
 }
 
-func updateDocFromSession(sess session) bson.M {
-	return bson.M{
-		"$set": bson.M{
-			"data":          sess.Data,
-			"last_modified": time.Now().UTC(),
-		},
+//updateDocFromSession builds the update persisting sess.  unsetFields are removed from
+//the stored document, e.g. leftovers of a legacy document format.
+func updateDocFromSession(sess session, unsetFields ...string) bson.M {
+	set := bson.M{
+		"data":          sess.Data,
+		"last_modified": sess.LastModified,
+	}
+	if sess.Name != "" {
+		set["name"] = sess.Name
+	}
+	if !sess.ExpiresAt.IsZero() {
+		set["expires_at"] = sess.ExpiresAt
+	}
+	unset := bson.M{}
+	for _, field := range unsetFields {
+		unset[field] = ""
+	}
+
+	for k, v := range sess.Extra {
+		set[k] = v
 	}
+
+	if len(sess.Values) > 0 {
+		set["values"] = sess.Values
+	} else {
+		unset["values"] = ""
+	}
+
+	if sess.Format != "" {
+		set["values_format"] = sess.Format
+	}
+
+	if sess.ValuesHash != "" {
+		set["values_hash"] = sess.ValuesHash
+	} else {
+		//a stale hash would let a later SkipUnchangedWrites save skip a real change
+		unset["values_hash"] = ""
+	}
+
+	//the fingerprint binds the session to the client that created it, so it is never
+	//overwritten
+	setOnInsert := bson.M{"created_at": sess.CreatedAt}
+	if sess.Fingerprint != "" {
+		setOnInsert["fingerprint"] = sess.Fingerprint
+	}
+
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": setOnInsert,
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	return update
 }
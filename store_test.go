@@ -2,17 +2,26 @@ package sessions_mongo
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-kit/kit/log"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -109,6 +118,41 @@ func (cs *CreationSuite) TestNewMongoDBStore() {
 			},
 			expectedErr: NewInvalidTTLErr(0 * time.Second),
 		},
+		{
+			description: "reserved BaseFilter field",
+			storeOptions: Options{
+				TTLOptions: TTLOptions{
+					TTL: 500 * time.Second,
+				},
+				BaseFilter: bson.M{"data": "tenant"},
+			},
+			sessionOptions: &sessions.Options{
+				Path:   "testPath",
+				MaxAge: 209,
+			},
+			expectedErr: NewReservedFieldErr("data"),
+		},
+		{
+			description: "invalid TTLByName",
+			storeOptions: Options{
+				TTLOptions: TTLOptions{
+					TTL:       500 * time.Second,
+					TTLByName: map[string]time.Duration{"short": -time.Second},
+				},
+			},
+			expectedErr: NewInvalidTTLErr(-time.Second),
+		},
+		{
+			description: "linearizable ReadConcern on secondary",
+			storeOptions: Options{
+				TTLOptions: TTLOptions{
+					TTL: 500 * time.Second,
+				},
+				ReadConcern:    readconcern.Linearizable(),
+				ReadPreference: readpref.Secondary(),
+			},
+			expectedErr: NewIncompatibleOptionsErr("linearizable ReadConcern requires a primary ReadPreference"),
+		},
 	}
 
 	for _, testCase := range tcs {
@@ -122,7 +166,9 @@ func (cs *CreationSuite) TestNewMongoDBStore() {
 				assert.Equal(t, cs.collection, store.collection)
 				assert.Equal(t, testCase.storeOptions, store.storeOptions)
 				assert.Equal(t, testCase.sessionOptions, store.defaultOptions)
-				assert.Equal(t, log.NewNopLogger(), store.logger)
+				require.IsType(t, &swappableLogger{}, store.logger)
+				assert.Equal(t, log.NewNopLogger(), store.logger.(*swappableLogger).current())
+				assert.Equal(t, testCase.storeOptions.TTLOptions.EnsureTTLIndex, store.TTLIndexEnsured())
 
 				if testCase.storeOptions.TTLOptions.EnsureTTLIndex {
 					cs.collection.Indexes().List(context.Background())
@@ -132,6 +178,203 @@ func (cs *CreationSuite) TestNewMongoDBStore() {
 	}
 }
 
+func (cs *CreationSuite) TestNewMongoDBStore_CollectionOptions() {
+	store, err := NewMongoDBStore(
+		cs.collection,
+		Options{
+			TTLOptions:     TTLOptions{TTL: 500 * time.Second},
+			ReadPreference: readpref.Primary(),
+			ReadConcern:    readconcern.Majority(),
+			WriteConcern:   writeconcern.New(writeconcern.WMajority()),
+		},
+		nil,
+		nil,
+	)
+	require.Nil(cs.T(), err)
+	assert.NotSame(cs.T(), cs.collection, store.collection)
+	assert.Equal(cs.T(), cs.collection.Name(), store.collection.Name())
+}
+
+func (cs *CreationSuite) TestNewMongoDBStore_TTLIndexConflict() {
+	require.Nil(cs.T(), cs.collection.Drop(context.Background()))
+	storeOptions := Options{TTLOptions: TTLOptions{TTL: 500 * time.Second, EnsureTTLIndex: true}}
+	_, err := NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	require.Nil(cs.T(), err)
+
+	storeOptions.TTLOptions.TTL = 600 * time.Second
+	_, err = NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	assert.Equal(cs.T(), ErrTTLIndexConflict, err)
+
+	storeOptions.TTLOptions.EnsureTTLIndex = false
+	store, err := NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	require.Nil(cs.T(), err)
+	assert.Nil(cs.T(), store.RebuildTTLIndex(context.Background()))
+
+	storeOptions.TTLOptions.EnsureTTLIndex = true
+	_, err = NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	assert.Nil(cs.T(), err)
+}
+
+func (cs *CreationSuite) TestNewMongoDBStore_AdditionalIndexes() {
+	storeOptions := Options{
+		TTLOptions: TTLOptions{TTL: 500 * time.Second},
+		AdditionalIndexes: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		},
+	}
+
+	_, err := NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	require.Nil(cs.T(), err)
+
+	cursor, err := cs.collection.Indexes().List(context.Background())
+	require.Nil(cs.T(), err)
+	var indexes []bson.M
+	require.Nil(cs.T(), cursor.All(context.Background(), &indexes))
+	var names []interface{}
+	for _, index := range indexes {
+		names = append(names, index["name"])
+	}
+	assert.Contains(cs.T(), names, "user_id_1")
+
+	_, err = cs.collection.Indexes().DropOne(context.Background(), "user_id_1")
+	require.Nil(cs.T(), err)
+}
+
+func (cs *CreationSuite) TestNewMongoDBStore_HealthMonitor() {
+	storeOptions := Options{
+		TTLOptions:    TTLOptions{TTL: 500 * time.Second},
+		HealthMonitor: HealthMonitorOptions{Enabled: true, Interval: 10 * time.Millisecond},
+	}
+	store, err := NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	require.Nil(cs.T(), err)
+	time.Sleep(50 * time.Millisecond)
+	assert.True(cs.T(), store.Healthy())
+	require.Nil(cs.T(), store.Close())
+
+	storeOptions.HealthMonitor.Interval = 0
+	_, err = NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	assert.IsType(cs.T(), &InvalidIntervalErr{}, err)
+}
+
+func (cs *CreationSuite) TestNewMongoDBStore_MaxAgeIsZero() {
+	store, err := NewMongoDBStore(cs.collection, Options{TTLOptions: TTLOptions{TTL: 500 * time.Second}},
+		&sessions.Options{Path: "/"}, nil, securecookie.CodecsFromPairs([]byte("abcdefghijklmnop"))...)
+	require.Nil(cs.T(), err)
+
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := store.New(r, "key")
+	require.Nil(cs.T(), err)
+	assert.Equal(cs.T(), 0, sess.Options.MaxAge)
+	sess.Values["user_id"] = "abc"
+	rw := NewMockResponseWriter()
+	require.Nil(cs.T(), store.Save(r, rw, sess))
+	assert.NotContains(cs.T(), rw.Header().Get("Set-Cookie"), "Max-Age")
+
+	exists, err := store.SessionExists(context.Background(), sess.ID)
+	require.Nil(cs.T(), err)
+	assert.True(cs.T(), exists, "sessions should be created despite MaxAge 0")
+}
+
+func (cs *CreationSuite) TestNewMongoDBStore_RequirePrimaryOnConnect() {
+	storeOptions := Options{
+		TTLOptions:              TTLOptions{TTL: 500 * time.Second},
+		RequirePrimaryOnConnect: true,
+	}
+	_, err := NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	assert.Nil(cs.T(), err)
+}
+
+func (cs *CreationSuite) TestNewMongoDBStore_SkipIndexCreation() {
+	require.Nil(cs.T(), cs.collection.Drop(context.Background()))
+	storeOptions := Options{
+		TTLOptions:        TTLOptions{TTL: 500 * time.Second, EnsureTTLIndex: true},
+		AdditionalIndexes: []mongo.IndexModel{{Keys: bson.D{{Key: "user_id", Value: 1}}}},
+		SkipIndexCreation: true,
+	}
+	store, err := NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	require.Nil(cs.T(), err)
+	assert.False(cs.T(), store.TTLIndexEnsured())
+
+	cursor, err := cs.collection.Indexes().List(context.Background())
+	require.Nil(cs.T(), err)
+	var indexes []bson.M
+	require.Nil(cs.T(), cursor.All(context.Background(), &indexes))
+	assert.Empty(cs.T(), indexes)
+
+	storeOptions.TTLOptions.TTL = 0
+	_, err = NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	assert.IsType(cs.T(), &InvalidTTLErr{}, err, "options should still be validated")
+}
+
+func (cs *CreationSuite) TestNewMongoDBStore_AdoptExistingIndexTTL() {
+	require.Nil(cs.T(), cs.collection.Drop(context.Background()))
+	storeOptions := Options{TTLOptions: TTLOptions{TTL: 500 * time.Second, AdoptExistingIndexTTL: true}}
+	store, err := NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	require.Nil(cs.T(), err)
+	assert.Equal(cs.T(), 500*time.Second, store.ttl, "the configured TTL should be used without an index")
+
+	_, err = cs.collection.Indexes().CreateOne(context.Background(), makeTTLIndexModel(900*time.Second))
+	require.Nil(cs.T(), err)
+	storeOptions.TTLOptions.EnsureTTLIndex = true
+	store, err = NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	require.Nil(cs.T(), err, "the adopted TTL should not conflict with the index")
+	assert.Equal(cs.T(), 900*time.Second, store.ttl)
+	assert.Equal(cs.T(), 900, store.defaultOptions.MaxAge)
+}
+
+func (cs *CreationSuite) TestNewMongoDBStore_Capped() {
+	storeOptions := Options{
+		TTLOptions:    TTLOptions{TTL: 500 * time.Second},
+		CappedOptions: CappedOptions{Enabled: true, SizeBytes: 1 << 20},
+	}
+
+	require.Nil(cs.T(), cs.collection.Drop(context.Background()))
+	_, err := NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	require.Nil(cs.T(), err)
+	_, err = NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	assert.Nil(cs.T(), err)
+
+	require.Nil(cs.T(), cs.collection.Drop(context.Background()))
+	_, err = cs.collection.InsertOne(context.Background(), bson.M{"uncapped": true})
+	require.Nil(cs.T(), err)
+	_, err = NewMongoDBStore(cs.collection, storeOptions, nil, nil)
+	assert.IsType(cs.T(), &IncompatibleOptionsErr{}, err)
+
+	require.Nil(cs.T(), cs.collection.Drop(context.Background()))
+}
+
+func (cs *CreationSuite) TestMongoDBStore_Capped_GrowingSession() {
+	require.Nil(cs.T(), cs.collection.Drop(context.Background()))
+	defer cs.collection.Drop(context.Background())
+	store, err := NewMongoDBStore(cs.collection, Options{
+		TTLOptions:    TTLOptions{TTL: 500 * time.Second},
+		CappedOptions: CappedOptions{Enabled: true, SizeBytes: 1 << 20},
+	}, nil, nil, securecookie.CodecsFromPairs([]byte("abcdefghijklmnop"))...)
+	require.Nil(cs.T(), err)
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(cs.T(), err)
+	sess.Values["cart"] = "item"
+	require.Nil(cs.T(), store.Save(&http.Request{}, nil, sess))
+	info, err := store.GetSessionInfo(context.Background(), sess.ID)
+	require.Nil(cs.T(), err)
+
+	sess.Values["cart"] = strings.Repeat("item,", 100)
+	result, err := store.SaveWithResult(&http.Request{}, nil, sess)
+	require.Nil(cs.T(), err, "a session growing in a capped collection should be saved")
+	assert.Equal(cs.T(), SaveUpdated, result)
+
+	loaded, err := store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(cs.T(), err)
+	assert.Equal(cs.T(), sess.Values["cart"], loaded.Values["cart"])
+	replaced, err := store.GetSessionInfo(context.Background(), sess.ID)
+	require.Nil(cs.T(), err)
+	assert.True(cs.T(), info.CreatedAt.Equal(replaced.CreatedAt), "the replacement should keep created_at")
+
+	err = store.UpdateValues(context.Background(), "session-key", sess.ID, func(map[interface{}]interface{}) error { return nil })
+	assert.IsType(cs.T(), &IncompatibleOptionsErr{}, err)
+}
+
 func TestMongoDBStore_Save(t *testing.T) {
 	ss := new(SaveSuite)
 	suite.Run(t, ss)
@@ -206,32 +449,2007 @@ func (ss *SaveSuite) TestMongoDBStore_Save_MaxAgeIsZero() {
 	assertSessionStoredProperlyInCookie(ss.T(), sessionKey, sess, ss.store, rw)
 
 	sess.Options.MaxAge = 0
+	rw = NewMockResponseWriter()
+	err = ss.store.Save(r, rw, sess)
+	assert.Nil(ss.T(), err)
+	cookie := rw.Header().Get("Set-Cookie")
+	assert.NotContains(ss.T(), cookie, "Max-Age")
+	assert.NotContains(ss.T(), cookie, "Expires")
+
+	err = ss.store.load(context.Background(), sess)
+	assert.Nil(ss.T(), err)
+	assert.Equal(ss.T(), "saved", sess.Values["will be"])
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Save_MaxAgeIsNegative() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := ss.store.New(r, "key")
+	require.Nil(ss.T(), err)
+	rw := NewMockResponseWriter()
+	require.Nil(ss.T(), ss.store.Save(r, rw, sess))
+
+	sess.Options.MaxAge = -1
 	err = ss.store.Save(r, rw, sess)
+	assert.Nil(ss.T(), err)
 
 	err = ss.store.load(context.Background(), sess)
 	assert.Equal(ss.T(), mongo.ErrNoDocuments, err)
 }
 
-func assertSessionStoredProperlyInCookie(
-	t *testing.T,
-	sessionKey string,
-	session *sessions.Session,
-	store *MongoDBStore,
-	rw http.ResponseWriter,
-) {
-	encodedSession, err := securecookie.EncodeMulti(sessionKey, session.ID, store.codecs...)
-	require.Nil(t, err)
-	expectedCookieString := sessions.NewCookie(sessionKey, encodedSession, store.defaultOptions).String()
-	cookieString := rw.Header().Get("Set-Cookie")
-	assert.Equal(t, expectedCookieString, cookieString)
+func (ss *SaveSuite) TestMongoDBStore_Save_StrictWriteMode() {
+	strictStore := *ss.store
+	strictStore.storeOptions.WriteMode = WriteModeStrict
+
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := strictStore.New(r, "key")
+	require.Nil(ss.T(), err)
+	stored := *sess
+	err = strictStore.Save(r, NewMockResponseWriter(), sess)
+	assert.Nil(ss.T(), err)
+
+	stored.IsNew = true
+	err = strictStore.Save(r, NewMockResponseWriter(), &stored)
+	assert.IsType(ss.T(), &DuplicateSessionIDErr{}, err)
+
+	missing := sessions.NewSession(&strictStore, "key")
+	missing.ID = primitive.NewObjectID().Hex()
+	missing.Options = strictStore.defaultOptions
+	missing.IsNew = false
+	err = strictStore.Save(r, NewMockResponseWriter(), missing)
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
 }
 
-func assertSessionStoredProperlyInDB(t *testing.T, expectedSession *sessions.Session, store *MongoDBStore) {
-	s := sessions.NewSession(store, expectedSession.Name())
-	s.Options = store.defaultOptions
-	s.ID = expectedSession.ID
-	err := store.load(context.Background(), s)
-	require.Nil(t, err)
+func (ss *SaveSuite) TestMongoDBStore_SessionExists() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := ss.store.New(r, "key")
+	require.Nil(ss.T(), err)
 
-	assert.Equal(t, expectedSession, s)
+	exists, err := ss.store.SessionExists(context.Background(), sess.ID)
+	assert.Nil(ss.T(), err)
+	assert.False(ss.T(), exists)
+
+	err = ss.store.Save(r, NewMockResponseWriter(), sess)
+	require.Nil(ss.T(), err)
+	exists, err = ss.store.SessionExists(context.Background(), sess.ID)
+	assert.Nil(ss.T(), err)
+	assert.True(ss.T(), exists)
+
+	_, err = ss.store.SessionExists(context.Background(), "abcdee")
+	assert.IsType(ss.T(), &InvalidSessionIDErr{}, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_GetSessionInfo() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := ss.store.New(r, "key")
+	require.Nil(ss.T(), err)
+
+	_, err = ss.store.GetSessionInfo(context.Background(), sess.ID)
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
+
+	err = ss.store.Save(r, NewMockResponseWriter(), sess)
+	require.Nil(ss.T(), err)
+	info, err := ss.store.GetSessionInfo(context.Background(), sess.ID)
+	assert.Nil(ss.T(), err)
+	assert.Equal(ss.T(), sess.ID, info.ID)
+	assert.False(ss.T(), info.LastModified.IsZero())
+}
+
+func (ss *SaveSuite) TestMongoDBStore_SaveAll() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	first, err := ss.store.New(r, "first")
+	require.Nil(ss.T(), err)
+	first.Values["n"] = 1
+	second, err := ss.store.New(r, "second")
+	require.Nil(ss.T(), err)
+	second.Values["n"] = 2
+	bad := sessions.NewSession(ss.store, "bad")
+	bad.ID = "abcdee"
+
+	err = ss.store.SaveAll(context.Background(), []*sessions.Session{first, bad, second})
+	require.IsType(ss.T(), &SaveAllErr{}, err)
+	failures := err.(*SaveAllErr).Failures()
+	assert.Len(ss.T(), failures, 1)
+	assert.Equal(ss.T(), primitive.ErrInvalidHex, failures["abcdee"])
+
+	assert.False(ss.T(), first.IsNew)
+	assert.False(ss.T(), second.IsNew)
+	assertSessionStoredProperlyInDB(ss.T(), first, ss.store)
+	assertSessionStoredProperlyInDB(ss.T(), second, ss.store)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_New_DecodeErrorReset() {
+	resetStore := *ss.store
+	resetStore.storeOptions.OnDecodeError = DecodeErrorReset
+	var failedIDs []string
+	resetStore.storeOptions.OnDecodeFailure = func(sessionID string, err error) {
+		failedIDs = append(failedIDs, sessionID)
+	}
+	failuresBefore := resetStore.DecodeFailures()
+
+	sessionKey := "key"
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := resetStore.New(r, sessionKey)
+	require.Nil(ss.T(), err)
+	sess.Values["will be"] = "corrupted"
+	rw := NewMockResponseWriter()
+	require.Nil(ss.T(), resetStore.Save(r, rw, sess))
+
+	oid, _ := primitive.ObjectIDFromHex(sess.ID)
+	_, err = ss.collection.UpdateOne(context.Background(), bson.M{"_id": oid}, bson.M{"$set": bson.M{"data": "garbage"}})
+	require.Nil(ss.T(), err)
+
+	r.Header.Set("Cookie", rw.Header().Get("Set-Cookie"))
+	fresh, err := resetStore.New(r, sessionKey)
+	assert.Nil(ss.T(), err)
+	assert.True(ss.T(), fresh.IsNew)
+	assert.NotEqual(ss.T(), sess.ID, fresh.ID)
+	assert.Empty(ss.T(), fresh.Values)
+	assert.Equal(ss.T(), []string{sess.ID}, failedIDs)
+	assert.Equal(ss.T(), failuresBefore+1, resetStore.DecodeFailures())
+
+	exists, err := resetStore.SessionExists(context.Background(), sess.ID)
+	assert.Nil(ss.T(), err)
+	assert.False(ss.T(), exists)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_NewWithID_DecodeErrorReset() {
+	resetStore := *ss.store
+	resetStore.storeOptions.OnDecodeError = DecodeErrorReset
+
+	id := primitive.NewObjectID()
+	_, err := ss.collection.InsertOne(context.Background(), bson.M{
+		"_id":           id,
+		"name":          "key",
+		"data":          "garbage",
+		"last_modified": time.Now(),
+	})
+	require.Nil(ss.T(), err)
+
+	fresh, err := resetStore.NewWithID(&http.Request{}, "key", id.Hex())
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), fresh.IsNew)
+	assert.Equal(ss.T(), id.Hex(), fresh.ID, "the requested ID should be kept")
+	assert.Empty(ss.T(), fresh.Values)
+
+	fresh.Values["user_id"] = "abc"
+	require.Nil(ss.T(), resetStore.Save(&http.Request{}, nil, fresh))
+	loaded, err := resetStore.NewWithID(&http.Request{}, "key", id.Hex())
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew)
+	assert.Equal(ss.T(), "abc", loaded.Values["user_id"])
+}
+
+func (ss *SaveSuite) TestMongoDBStore_LoadLegacyDocument() {
+	legacyStore := *ss.store
+	legacyStore.storeOptions.LegacyOptions = LegacyOptions{
+		Enabled:       true,
+		DataField:     "session_data",
+		RemovedFields: []string{"modified"},
+	}
+
+	oid := primitive.NewObjectID()
+	_, err := ss.collection.InsertOne(context.Background(), bson.M{
+		"_id":          oid,
+		"session_data": `{"user":"gopher","visits":3}`,
+		"modified":     time.Now(),
+	})
+	require.Nil(ss.T(), err)
+
+	sess := sessions.NewSession(&legacyStore, "key")
+	sess.ID = oid.Hex()
+	sess.Options = legacyStore.defaultOptions
+	err = legacyStore.load(context.Background(), sess)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), map[interface{}]interface{}{"user": "gopher", "visits": float64(3)}, sess.Values)
+
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess.IsNew = false
+	require.Nil(ss.T(), legacyStore.Save(r, NewMockResponseWriter(), sess))
+
+	var stored bson.M
+	err = ss.collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&stored)
+	require.Nil(ss.T(), err)
+	assert.NotContains(ss.T(), stored, "session_data")
+	assert.NotContains(ss.T(), stored, "modified")
+	assertSessionStoredProperlyInDB(ss.T(), sess, &legacyStore)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_RefreshOnLoad_LegacyDocument() {
+	legacyStore := *ss.store
+	legacyStore.storeOptions.LegacyOptions = LegacyOptions{Enabled: true, DataField: "session_data"}
+	legacyStore.storeOptions.TTLOptions.RefreshOnLoad = true
+
+	oid := primitive.NewObjectID()
+	_, err := ss.collection.InsertOne(context.Background(), bson.M{
+		"_id":          oid,
+		"session_data": `{"user":"gopher"}`,
+	})
+	require.Nil(ss.T(), err)
+
+	for i := 0; i < 2; i++ {
+		sess, err := legacyStore.NewWithID(&http.Request{}, "key", oid.Hex())
+		require.Nil(ss.T(), err)
+		assert.False(ss.T(), sess.IsNew)
+		assert.Equal(ss.T(), "gopher", sess.Values["user"], "load %d should read the legacy document", i)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := legacyStore.NewWithID(r, "key", oid.Hex())
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), legacyStore.Save(r, nil, sess))
+	var stored session
+	require.Nil(ss.T(), ss.collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&stored))
+	assert.False(ss.T(), stored.LastModified.IsZero(), "saving should rewrite the legacy document")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_LoadAndTouch_LegacyDocument() {
+	legacyStore := *ss.store
+	legacyStore.storeOptions.LegacyOptions = LegacyOptions{Enabled: true, DataField: "session_data"}
+
+	oid := primitive.NewObjectID()
+	_, err := ss.collection.InsertOne(context.Background(), bson.M{
+		"_id":          oid,
+		"session_data": `{"user":"gopher"}`,
+	})
+	require.Nil(ss.T(), err)
+
+	for i := 0; i < 2; i++ {
+		sess, err := legacyStore.LoadAndTouch(context.Background(), "key", oid.Hex())
+		require.Nil(ss.T(), err)
+		assert.Equal(ss.T(), "gopher", sess.Values["user"], "load %d should read the legacy document", i)
+	}
+
+	_, err = legacyStore.LoadAndTouch(context.Background(), "key", primitive.NewObjectID().Hex())
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Stats() {
+	require.Nil(ss.T(), ss.collection.Drop(context.Background()))
+	usersStore := *ss.store
+	usersStore.storeOptions.UserIDField = "user_id"
+
+	for _, userID := range []string{"a", "a", "b"} {
+		_, err := ss.collection.InsertOne(context.Background(), bson.M{
+			"_id":           primitive.NewObjectID(),
+			"data":          "",
+			"last_modified": time.Now().UTC(),
+			"user_id":       userID,
+		})
+		require.Nil(ss.T(), err)
+	}
+	_, err := ss.collection.InsertOne(context.Background(), bson.M{
+		"_id":           primitive.NewObjectID(),
+		"data":          "",
+		"last_modified": time.Now().UTC().Add(-2 * time.Hour),
+	})
+	require.Nil(ss.T(), err)
+
+	stats, err := usersStore.Stats(context.Background())
+	assert.Nil(ss.T(), err)
+	assert.Equal(ss.T(), StoreStats{TotalSessions: 4, ModifiedLastHour: 3, DistinctUsers: 2}, stats)
+
+	active, err := usersStore.ActiveUsers(context.Background(), 10*time.Minute)
+	assert.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(2), active)
+	_, err = ss.store.ActiveUsers(context.Background(), 10*time.Minute)
+	assert.Equal(ss.T(), ErrNoUserIDField, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Save_MaxAgeIsZero_NeverPersisted() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	for _, id := range []string{"", "abcdee", primitive.NewObjectID().Hex()} {
+		sess := sessions.NewSession(ss.store, "key")
+		sess.ID = id
+		sess.IsNew = false
+		sess.Options = &sessions.Options{MaxAge: -1}
+
+		rw := NewMockResponseWriter()
+		err := ss.store.Save(r, rw, sess)
+		assert.Nil(ss.T(), err)
+		assert.Equal(ss.T(), sessions.NewCookie("key", "", sess.Options).String(), rw.Header().Get("Set-Cookie"))
+	}
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Save_NilResponseWriter() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := ss.store.New(r, "key")
+	require.Nil(ss.T(), err)
+	sess.Values["persisted"] = "without cookie"
+
+	err = ss.store.Save(r, nil, sess)
+	assert.Nil(ss.T(), err)
+	assertSessionStoredProperlyInDB(ss.T(), sess, ss.store)
+
+	encodedID, err := ss.store.EncodedID(sess)
+	assert.Nil(ss.T(), err)
+	var decodedID string
+	err = securecookie.DecodeMulti("key", encodedID, &decodedID, ss.store.codecs...)
+	assert.Nil(ss.T(), err)
+	assert.Equal(ss.T(), sess.ID, decodedID)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Save_CookieOptionsHook() {
+	hookStore := *ss.store
+	hookStore.storeOptions.CookieOptionsHook = SameSiteNoneOverTLS
+
+	r, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	r.TLS = &tls.ConnectionState{}
+	sess, err := hookStore.New(r, "key")
+	require.Nil(ss.T(), err)
+
+	rw := NewMockResponseWriter()
+	require.Nil(ss.T(), hookStore.Save(r, rw, sess))
+	cookie := rw.Header().Get("Set-Cookie")
+	assert.Contains(ss.T(), cookie, "SameSite=None")
+	assert.Contains(ss.T(), cookie, "Secure")
+	assert.Equal(ss.T(), http.SameSite(0), sess.Options.SameSite)
+
+	r.TLS = nil
+	rw = NewMockResponseWriter()
+	require.Nil(ss.T(), hookStore.Save(r, rw, sess))
+	cookie = rw.Header().Get("Set-Cookie")
+	assert.Contains(ss.T(), cookie, "SameSite=Lax")
+	assert.NotContains(ss.T(), cookie, "Secure")
+
+	sess.Options.Secure = true
+	rw = NewMockResponseWriter()
+	require.Nil(ss.T(), hookStore.Save(r, rw, sess))
+	cookie = rw.Header().Get("Set-Cookie")
+	assert.Contains(ss.T(), cookie, "SameSite=Lax")
+	assert.Contains(ss.T(), cookie, "Secure", "a configured Secure should be kept behind a TLS terminating proxy")
+}
+
+func TestSameSiteNoneOverTLS(t *testing.T) {
+	opts := &sessions.Options{Secure: true}
+	SameSiteNoneOverTLS(&http.Request{}, opts)
+	assert.Equal(t, http.SameSiteLaxMode, opts.SameSite)
+	assert.True(t, opts.Secure)
+
+	opts = &sessions.Options{}
+	SameSiteNoneOverTLS(&http.Request{TLS: &tls.ConnectionState{}}, opts)
+	assert.Equal(t, http.SameSiteNoneMode, opts.SameSite)
+	assert.True(t, opts.Secure)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_ExportSession() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := ss.store.New(r, "key")
+	require.Nil(ss.T(), err)
+	sess.Values["user"] = "gopher"
+	sess.Values["secret"] = "hunter2"
+	require.Nil(ss.T(), ss.store.Save(r, NewMockResponseWriter(), sess))
+
+	redactSecret := func(key string, value interface{}) interface{} {
+		if key == "secret" {
+			return "REDACTED"
+		}
+		return value
+	}
+	data, err := ss.store.ExportSession(context.Background(), "key", sess.ID, redactSecret)
+	require.Nil(ss.T(), err)
+
+	var exported ExportedSession
+	require.Nil(ss.T(), json.Unmarshal(data, &exported))
+	assert.Equal(ss.T(), sess.ID, exported.ID)
+	assert.Equal(ss.T(), "key", exported.Name)
+	assert.Equal(ss.T(), map[string]interface{}{"user": "gopher", "secret": "REDACTED"}, exported.Values)
+
+	_, err = ss.store.ExportSession(context.Background(), "key", primitive.NewObjectID().Hex())
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_ImportSession() {
+	oid := primitive.NewObjectID()
+	payload := []byte(`{"id":"` + oid.Hex() + `","name":"key","values":{"user":"gopher","visits":3}}`)
+	id, err := ss.store.ImportSession(context.Background(), payload)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), oid.Hex(), id)
+
+	sess := sessions.NewSession(ss.store, "key")
+	sess.ID = id
+	require.Nil(ss.T(), ss.store.load(context.Background(), sess))
+	assert.Equal(ss.T(), map[interface{}]interface{}{"user": "gopher", "visits": float64(3)}, sess.Values)
+
+	nested := []byte(`{"name":"key","values":{"profile":{"name":"gopher"},"roles":["admin","user"]}}`)
+	id, err = ss.store.ImportSession(context.Background(), nested)
+	require.Nil(ss.T(), err)
+	sess = sessions.NewSession(ss.store, "key")
+	sess.ID = id
+	require.Nil(ss.T(), ss.store.load(context.Background(), sess))
+	assert.Equal(ss.T(), map[string]interface{}{"name": "gopher"}, sess.Values["profile"])
+	assert.Equal(ss.T(), []interface{}{"admin", "user"}, sess.Values["roles"])
+	exported, err := ss.store.ExportSession(context.Background(), "key", id)
+	require.Nil(ss.T(), err)
+	_, err = ss.store.ImportSession(context.Background(), exported)
+	assert.Nil(ss.T(), err, "an export should import again")
+
+	id, err = ss.store.ImportSession(context.Background(), []byte(`{"name":"key","values":{}}`))
+	assert.Nil(ss.T(), err)
+	assert.True(ss.T(), isValidSessionID(id))
+
+	for _, malformed := range []string{
+		`not json`,
+		`{"name":"key"}`,
+		`{"values":{}}`,
+		`{"id":"abcdee","name":"key","values":{}}`,
+		`{"name":"key","values":{},"unknown":true}`,
+	} {
+		_, err = ss.store.ImportSession(context.Background(), []byte(malformed))
+		assert.IsType(ss.T(), &InvalidImportErr{}, err, malformed)
+	}
+}
+
+func (ss *SaveSuite) TestMongoDBStore_New_UndecodableCookie() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	r.AddCookie(&http.Cookie{Name: "key", Value: "tampered"})
+
+	sess, err := ss.store.New(r, "key")
+	assert.NotNil(ss.T(), err)
+	require.NotNil(ss.T(), sess)
+	assert.True(ss.T(), sess.IsNew)
+	assert.True(ss.T(), isValidSessionID(sess.ID))
+	assert.Empty(ss.T(), sess.Values)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_LegacyCookieNames() {
+	store := *ss.store
+	store.storeOptions.LegacyCookieNames = []string{"old-key"}
+
+	legacy, err := store.New(&http.Request{}, "old-key")
+	require.Nil(ss.T(), err)
+	legacy.Values["user_id"] = "abc"
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, legacy))
+	encodedID, err := store.EncodedID(legacy)
+	require.Nil(ss.T(), err)
+
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	r.AddCookie(&http.Cookie{Name: "old-key", Value: encodedID})
+	sess, err := store.New(r, "key")
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), sess.IsNew)
+	assert.Equal(ss.T(), legacy.ID, sess.ID)
+	assert.Equal(ss.T(), "abc", sess.Values["user_id"])
+
+	w := NewMockResponseWriter()
+	require.Nil(ss.T(), store.Save(r, w, sess))
+	cookies := (&http.Response{Header: w.Header()}).Cookies()
+	require.Len(ss.T(), cookies, 2)
+	assert.Equal(ss.T(), "key", cookies[0].Name)
+	assert.Equal(ss.T(), "old-key", cookies[1].Name)
+	assert.True(ss.T(), cookies[1].MaxAge < 0, "the legacy cookie should be expired")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_New_EmptyDecodedID() {
+	encodedID, err := ss.store.idCodec().Encode("key", "")
+	require.Nil(ss.T(), err)
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	r.AddCookie(&http.Cookie{Name: "key", Value: encodedID})
+
+	sess, err := ss.store.New(r, "key")
+	assert.Nil(ss.T(), err)
+	require.NotNil(ss.T(), sess)
+	assert.True(ss.T(), sess.IsNew)
+	assert.True(ss.T(), isValidSessionID(sess.ID))
+}
+
+func (ss *SaveSuite) TestMongoDBStore_ScopedByName() {
+	sess, err := ss.store.New(&http.Request{}, "cart")
+	require.Nil(ss.T(), err)
+	sess.Values["items"] = 3
+	require.Nil(ss.T(), ss.store.Save(&http.Request{}, nil, sess))
+
+	var stored session
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), ss.collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&stored))
+	assert.Equal(ss.T(), "cart", stored.Name)
+
+	other, err := ss.store.NewWithID(&http.Request{}, "prefs", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), other.IsNew, "a session should not load under another name")
+	assert.NotNil(ss.T(), ss.store.Save(&http.Request{}, nil, other), "a session should not be overwritten under another name")
+
+	loaded, err := ss.store.NewWithID(&http.Request{}, "cart", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew)
+	assert.Equal(ss.T(), 3, loaded.Values["items"])
+}
+
+func (ss *SaveSuite) TestMongoDBStore_ValidateCookie() {
+	store := *ss.store
+	sess, err := store.New(&http.Request{}, "key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	encodedID, err := store.EncodedID(sess)
+	require.Nil(ss.T(), err)
+
+	valid, err := store.ValidateCookie(context.Background(), "key", encodedID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), sess.ID, valid.ID)
+	assert.Equal(ss.T(), "abc", valid.Values["user_id"])
+
+	_, err = store.ValidateCookie(context.Background(), "key", "tampered")
+	assert.IsType(ss.T(), &InvalidCookieErr{}, err)
+
+	unknownSess := sessions.NewSession(&store, "key")
+	unknownSess.ID = primitive.NewObjectID().Hex()
+	unknown, err := store.EncodedID(unknownSess)
+	require.Nil(ss.T(), err)
+	_, err = store.ValidateCookie(context.Background(), "key", unknown)
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
+
+	store.storeOptions.Clock = func() time.Time { return time.Now().Add(store.ttl + time.Minute) }
+	_, err = store.ValidateCookie(context.Background(), "key", encodedID)
+	assert.Equal(ss.T(), ErrSessionExpired, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_ValidateBound() {
+	store := *ss.store
+	store.storeOptions.Binding = BindingOptions{Enabled: true}
+
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	r.RemoteAddr = "203.0.113.7:4321"
+	r.Header.Set("User-Agent", "browser")
+	sess, err := store.New(r, "key")
+	require.Nil(ss.T(), err)
+	assert.Nil(ss.T(), store.ValidateBound(r, sess), "new sessions should pass")
+	require.Nil(ss.T(), store.Save(r, nil, sess))
+
+	sameNetwork, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sameNetwork.RemoteAddr = "203.0.113.42:1234"
+	sameNetwork.Header.Set("User-Agent", "browser")
+	assert.Nil(ss.T(), store.ValidateBound(sameNetwork, sess))
+
+	stolen, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	stolen.RemoteAddr = "198.51.100.1:1234"
+	stolen.Header.Set("User-Agent", "browser")
+	assert.Equal(ss.T(), ErrSessionBindingMismatch, store.ValidateBound(stolen, sess))
+
+	require.Nil(ss.T(), store.Save(stolen, nil, sess))
+	assert.Equal(ss.T(), ErrSessionBindingMismatch, store.ValidateBound(stolen, sess),
+		"saving from another client should not rebind the session")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_NewWithID() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	id := primitive.NewObjectID().Hex()
+
+	sess, err := ss.store.NewWithID(r, "key", id)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), sess.IsNew)
+	assert.Equal(ss.T(), id, sess.ID)
+	sess.Values["provisioned"] = true
+	require.Nil(ss.T(), ss.store.Save(r, NewMockResponseWriter(), sess))
+
+	loaded, err := ss.store.NewWithID(r, "key", id)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew)
+	assert.Equal(ss.T(), sess.Values, loaded.Values)
+
+	_, err = ss.store.NewWithID(r, "key", "abcdee")
+	assert.IsType(ss.T(), &InvalidSessionIDErr{}, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Save_ExtraFields() {
+	extraStore := *ss.store
+	extraStore.storeOptions.ExtraFields = func(sess *sessions.Session) bson.M {
+		return bson.M{"role": sess.Values["role"]}
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := extraStore.New(r, "key")
+	require.Nil(ss.T(), err)
+	sess.Values["role"] = "admin"
+	require.Nil(ss.T(), extraStore.Save(r, NewMockResponseWriter(), sess))
+
+	oid, _ := primitive.ObjectIDFromHex(sess.ID)
+	count, err := ss.collection.CountDocuments(context.Background(), bson.M{"_id": oid, "role": "admin"})
+	assert.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(1), count)
+
+	extraStore.storeOptions.ExtraFields = func(sess *sessions.Session) bson.M {
+		return bson.M{"last_modified": "never"}
+	}
+	err = extraStore.Save(r, NewMockResponseWriter(), sess)
+	assert.Equal(ss.T(), NewReservedFieldErr("last_modified"), err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Delete() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := ss.store.New(r, "key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), ss.store.Save(r, NewMockResponseWriter(), sess))
+
+	deleted, err := ss.store.Delete(context.Background(), sess.ID)
+	assert.Nil(ss.T(), err)
+	assert.True(ss.T(), deleted)
+
+	deleted, err = ss.store.Delete(context.Background(), sess.ID)
+	assert.Nil(ss.T(), err)
+	assert.False(ss.T(), deleted)
+
+	_, err = ss.store.Delete(context.Background(), "abcdee")
+	assert.IsType(ss.T(), &InvalidSessionIDErr{}, err)
+}
+
+type reversingIDCodec struct{}
+
+func (reversingIDCodec) Encode(name, id string) (string, error) {
+	reversed := []byte(id)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	return name + "." + string(reversed), nil
+}
+
+func (c reversingIDCodec) Decode(name, encoded string) (string, error) {
+	return c.Encode("", strings.TrimPrefix(encoded, name+"."))
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Save_CustomIDCodec() {
+	codecStore := *ss.store
+	codecStore.storeOptions.IDCodec = reversingIDCodec{}
+
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := codecStore.New(r, "key")
+	require.Nil(ss.T(), err)
+	sess.Values["codec"] = "custom"
+	rw := NewMockResponseWriter()
+	require.Nil(ss.T(), codecStore.Save(r, rw, sess))
+
+	encodedID, _ := reversingIDCodec{}.Encode("key", sess.ID)
+	assert.Equal(ss.T(), sessions.NewCookie("key", encodedID, sess.Options).String(), rw.Header().Get("Set-Cookie"))
+
+	r.Header.Set("Cookie", rw.Header().Get("Set-Cookie"))
+	loaded, err := codecStore.New(r, "key")
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), sess.ID, loaded.ID)
+	assert.Equal(ss.T(), sess.Values, loaded.Values)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_WithCollection() {
+	other := ss.collection.Database().Collection(ss.collection.Name() + "_next")
+	defer other.Drop(context.Background())
+
+	derived, err := ss.store.WithCollection(other)
+	require.Nil(ss.T(), err)
+
+	sess, err := derived.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), derived.Save(&http.Request{}, nil, sess))
+
+	exists, err := derived.SessionExists(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), exists)
+	exists, err = ss.store.SessionExists(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), exists)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_SessionKeys() {
+	sess, err := ss.store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "secret"
+	sess.Values[42] = true
+	require.Nil(ss.T(), ss.store.Save(&http.Request{}, nil, sess))
+
+	keys, err := ss.store.SessionKeys(context.Background(), "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), []string{"42", "user_id"}, keys)
+
+	_, err = ss.store.SessionKeys(context.Background(), "session-key", primitive.NewObjectID().Hex())
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_MigrateFormat() {
+	sess, err := ss.store.New(&http.Request{}, "migrate-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), ss.store.Save(&http.Request{}, nil, sess))
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	before, err := ss.store.findSession(context.Background(), oid, "")
+	require.Nil(ss.T(), err)
+
+	migrated, err := ss.store.MigrateFormat(context.Background(), "migrate-key", SerializationBSON)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), migrated >= 1)
+
+	after, err := ss.store.findSession(context.Background(), oid, "")
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), "bson", after.Format)
+	assert.Equal(ss.T(), "abc", after.Values.Lookup("user_id").StringValue())
+	assert.Equal(ss.T(), before.LastModified, after.LastModified)
+
+	loaded, err := ss.store.NewWithID(&http.Request{}, "migrate-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), "abc", loaded.Values["user_id"])
+
+	migrated, err = ss.store.MigrateFormat(context.Background(), "migrate-key", SerializationBSON)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(0), migrated)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	migrated, err = ss.store.MigrateFormat(ctx, "migrate-key", SerializationGob)
+	assert.Error(ss.T(), err)
+	assert.Equal(ss.T(), int64(0), migrated)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_MigrateFormat_OtherName() {
+	store := *ss.store
+	store.storeOptions.Serialization = SerializationBSON
+	ids := make(map[string]string)
+	for _, name := range []string{"migrate-a", "migrate-b"} {
+		sess, err := store.New(&http.Request{}, name)
+		require.Nil(ss.T(), err)
+		sess.Values["name"] = name
+		require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+		ids[name] = sess.ID
+	}
+
+	_, err := store.MigrateFormat(context.Background(), "migrate-a", SerializationGob)
+	require.Nil(ss.T(), err)
+
+	oid, err := primitive.ObjectIDFromHex(ids["migrate-b"])
+	require.Nil(ss.T(), err)
+	other, err := store.findSession(context.Background(), oid, "")
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), "migrate-b", other.Name, "migrating a name should leave other names alone")
+	assert.Equal(ss.T(), "bson", other.Format)
+	loaded, err := store.NewWithID(&http.Request{}, "migrate-b", ids["migrate-b"])
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew)
+	assert.Equal(ss.T(), "migrate-b", loaded.Values["name"])
+
+	gobStore := *ss.store
+	loaded, err = gobStore.NewWithID(&http.Request{}, "migrate-a", ids["migrate-a"])
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), "migrate-a", loaded.Values["name"])
+}
+
+func (ss *SaveSuite) TestMongoDBStore_MigrateFormat_Tombstone() {
+	store := *ss.store
+	store.storeOptions.SoftDelete = SoftDeleteOptions{Enabled: true, RetainFor: time.Hour}
+	sess, err := store.New(&http.Request{}, "migrate-tombstone")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	_, err = store.Delete(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+
+	_, err = store.MigrateFormat(context.Background(), "migrate-tombstone", SerializationBSON)
+	require.Nil(ss.T(), err)
+
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	var tombstone session
+	require.Nil(ss.T(), ss.collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&tombstone))
+	assert.False(ss.T(), tombstone.DeletedAt.IsZero(), "migrating should not revive a tombstone")
+	assert.True(ss.T(), tombstone.LastModified.IsZero())
+}
+
+func (ss *SaveSuite) TestMongoDBStore_LoadProjection() {
+	store := *ss.store
+	store.storeOptions.LoadProjection = StoreFieldsProjection()
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	_, err = ss.collection.UpdateOne(context.Background(), bson.M{"_id": oid}, bson.M{"$set": bson.M{"other_tool": "x"}})
+	require.Nil(ss.T(), err)
+
+	s, err := store.findSession(context.Background(), oid, "")
+	require.Nil(ss.T(), err)
+	assert.NotContains(ss.T(), s.Extra, "other_tool")
+
+	loaded, err := store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), "abc", loaded.Values["user_id"])
+}
+
+func (ss *SaveSuite) TestMongoDBStore_LoadAndTouch() {
+	store := *ss.store
+	store.storeOptions.TTLOptions.EnforceOnRead = true
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	before, err := store.findSession(context.Background(), oid, "")
+	require.Nil(ss.T(), err)
+
+	time.Sleep(10 * time.Millisecond)
+	loaded, err := store.LoadAndTouch(context.Background(), "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), "abc", loaded.Values["user_id"])
+	after, err := store.findSession(context.Background(), oid, "")
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), after.LastModified.After(before.LastModified))
+
+	expired := time.Now().Add(-time.Hour)
+	_, err = ss.collection.UpdateOne(context.Background(), bson.M{"_id": oid},
+		bson.M{"$set": bson.M{"last_modified": expired, "expires_at": expired}})
+	require.Nil(ss.T(), err)
+	_, err = store.LoadAndTouch(context.Background(), "session-key", sess.ID)
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Flush() {
+	store := *ss.store
+	var err error
+	store.collection, err = ss.collection.Clone(options.Collection().SetWriteConcern(writeconcern.New(writeconcern.W(0))))
+	require.Nil(ss.T(), err)
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	require.Nil(ss.T(), store.Flush(context.Background()))
+
+	exists, err := ss.store.SessionExists(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), exists)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_UnacknowledgedWrites() {
+	store := *ss.store
+	var err error
+	store.collection, err = ss.collection.Clone(options.Collection().SetWriteConcern(writeconcern.New(writeconcern.W(0))))
+	require.Nil(ss.T(), err)
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	result, err := store.SaveWithResult(&http.Request{}, nil, sess)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), SaveNotWritten, result, "the outcome of an unacknowledged save is unknown")
+	assert.False(ss.T(), sess.IsNew)
+
+	other := sessions.NewSession(&store, "session-key")
+	require.Nil(ss.T(), store.SaveAll(context.Background(), []*sessions.Session{other}))
+	require.Nil(ss.T(), store.Flush(context.Background()))
+
+	for _, id := range []string{sess.ID, other.ID} {
+		exists, err := ss.store.SessionExists(context.Background(), id)
+		require.Nil(ss.T(), err)
+		assert.True(ss.T(), exists)
+	}
+
+	_, err = store.Delete(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	sess.Options.MaxAge = -1
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	require.Nil(ss.T(), store.Flush(context.Background()))
+	exists, err := ss.store.SessionExists(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), exists)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Save_Coalesced() {
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	sess, err := ss.store.New(r, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), ss.store.Save(r, nil, sess))
+
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	_, err = ss.collection.DeleteOne(context.Background(), bson.M{"_id": oid})
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), ss.store.Save(r, nil, sess))
+	exists, err := ss.store.SessionExists(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), exists, "unchanged session should not be written twice in a request")
+
+	uncoalesced := *ss.store
+	uncoalesced.storeOptions.DisableSaveCoalescing = true
+	require.Nil(ss.T(), uncoalesced.Save(r, nil, sess))
+	exists, err = ss.store.SessionExists(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), exists)
+
+	_, err = ss.collection.DeleteOne(context.Background(), bson.M{"_id": oid})
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "def"
+	require.Nil(ss.T(), ss.store.Save(r, nil, sess))
+	exists, err = ss.store.SessionExists(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), exists, "changed session should be written")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_LayoutSubdocument() {
+	store := *ss.store
+	store.storeOptions.Layout = LayoutSubdocument
+	userID := primitive.NewObjectID().Hex()
+
+	for _, name := range []string{"cart", "prefs"} {
+		sess, err := store.NewWithID(&http.Request{}, name, userID)
+		require.Nil(ss.T(), err)
+		require.True(ss.T(), sess.IsNew)
+		sess.Values["name"] = name
+		require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	}
+
+	oid, _ := primitive.ObjectIDFromHex(userID)
+	count, err := ss.collection.CountDocuments(context.Background(), bson.M{"_id": oid})
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(1), count)
+
+	cart, err := store.NewWithID(&http.Request{}, "cart", userID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), cart.IsNew)
+	assert.Equal(ss.T(), "cart", cart.Values["name"])
+
+	cart.Options.MaxAge = -1
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, cart))
+	cart, err = store.NewWithID(&http.Request{}, "cart", userID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), cart.IsNew)
+	prefs, err := store.NewWithID(&http.Request{}, "prefs", userID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), "prefs", prefs.Values["name"])
+
+	_, err = store.NewWithID(&http.Request{}, "a.b", userID)
+	assert.IsType(ss.T(), &InvalidSessionNameErr{}, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_CookieDisabled() {
+	store := *ss.store
+	store.storeOptions.CookieDisabled = true
+	store.storeOptions.HeaderName = "X-Session"
+
+	sess, err := store.New(&http.Request{Header: http.Header{}}, "key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	rw := NewMockResponseWriter()
+	require.Nil(ss.T(), store.Save(&http.Request{}, rw, sess))
+	assert.Empty(ss.T(), rw.Header().Get("Set-Cookie"))
+	encodedID := rw.Header().Get("X-Session")
+	require.NotEmpty(ss.T(), encodedID)
+
+	r := &http.Request{Header: http.Header{"X-Session": []string{encodedID}}}
+	loaded, err := store.New(r, "key")
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew)
+	assert.Equal(ss.T(), "abc", loaded.Values["user_id"])
+
+	loaded.Options.MaxAge = -1
+	rw = NewMockResponseWriter()
+	require.Nil(ss.T(), store.Save(r, rw, loaded))
+	assert.Equal(ss.T(), []string{""}, rw.Header()["X-Session"])
+}
+
+func (ss *SaveSuite) TestMongoDBStore_RefreshOnLoad() {
+	store := *ss.store
+	store.storeOptions.TTLOptions.RefreshOnLoad = true
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	oid, _ := primitive.ObjectIDFromHex(sess.ID)
+	stale := time.Now().UTC().Add(-time.Minute).Truncate(time.Millisecond)
+	_, err = ss.collection.UpdateOne(context.Background(), bson.M{"_id": oid}, bson.M{"$set": bson.M{"last_modified": stale}})
+	require.Nil(ss.T(), err)
+
+	_, err = store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	refreshed, err := store.findSession(context.Background(), oid, "")
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), refreshed.LastModified.After(stale))
+}
+
+func (ss *SaveSuite) TestMongoDBStore_IterateSessions() {
+	require.Nil(ss.T(), ss.collection.Drop(context.Background()))
+	saved := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		sess, err := ss.store.New(&http.Request{}, "session-key")
+		require.Nil(ss.T(), err)
+		require.Nil(ss.T(), ss.store.Save(&http.Request{}, nil, sess))
+		saved[sess.ID] = true
+	}
+
+	iterated := make(map[string]bool)
+	err := ss.store.IterateSessions(context.Background(), func(info SessionInfo) error {
+		iterated[info.ID] = true
+		return nil
+	})
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), saved, iterated)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = ss.store.IterateSessions(context.Background(), func(SessionInfo) error {
+		calls++
+		return stop
+	})
+	assert.Equal(ss.T(), stop, err)
+	assert.Equal(ss.T(), 1, calls)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_RawDocument() {
+	sess, err := ss.store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), ss.store.Save(&http.Request{}, nil, sess))
+	oid, _ := primitive.ObjectIDFromHex(sess.ID)
+	_, err = ss.collection.UpdateOne(context.Background(), bson.M{"_id": oid}, bson.M{"$set": bson.M{"other_tool": "x"}})
+	require.Nil(ss.T(), err)
+
+	doc, err := ss.store.RawDocument(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), oid, doc["_id"])
+	assert.Equal(ss.T(), "x", doc["other_tool"])
+	assert.NotEmpty(ss.T(), doc["data"])
+
+	_, err = ss.store.RawDocument(context.Background(), primitive.NewObjectID().Hex())
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
+	_, err = ss.store.RawDocument(context.Background(), "not-an-id")
+	assert.IsType(ss.T(), &InvalidSessionIDErr{}, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_OnInvalidate() {
+	var invalidated []string
+	store := *ss.store
+	store.storeOptions.OnInvalidate = func(sessionID string) {
+		invalidated = append(invalidated, sessionID)
+	}
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	assert.Empty(ss.T(), invalidated)
+
+	_, err = store.Delete(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	_, err = store.Delete(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), []string{sess.ID}, invalidated)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_SoftDelete() {
+	store := *ss.store
+	store.storeOptions.SoftDelete = SoftDeleteOptions{Enabled: true, RetainFor: time.Hour}
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+
+	deleted, err := store.Delete(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), deleted)
+	deleted, err = store.Delete(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), deleted, "deleting a tombstone should not report a deletion")
+
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	var tombstone session
+	require.Nil(ss.T(), ss.collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&tombstone))
+	assert.False(ss.T(), tombstone.DeletedAt.IsZero())
+	assert.True(ss.T(), tombstone.LastModified.IsZero())
+	assert.Empty(ss.T(), tombstone.ValuesHash)
+
+	exists, err := store.SessionExists(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), exists, "a tombstone should not count as stored")
+	_, err = store.GetSessionInfo(context.Background(), sess.ID)
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
+
+	_, err = store.LoadAndTouch(context.Background(), "session-key", sess.ID)
+	assert.Equal(ss.T(), ErrSessionNotFound, err, "a tombstone should not be touched")
+	require.Nil(ss.T(), ss.collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&tombstone))
+	assert.True(ss.T(), tombstone.LastModified.IsZero())
+
+	loaded, err := store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), loaded.IsNew, "a soft deleted session should not load")
+
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	loaded, err = store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew, "saving should replace the tombstone")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Sweep() {
+	store := *ss.store
+	store.storeOptions.SoftDelete = SoftDeleteOptions{Enabled: true, RetainFor: time.Hour}
+
+	now := time.Now()
+	expired := primitive.NewObjectID()
+	purged := primitive.NewObjectID()
+	retained := primitive.NewObjectID()
+	_, err := ss.collection.InsertMany(context.Background(), []interface{}{
+		bson.M{"_id": expired, "data": "", "last_modified": now.Add(-2 * time.Hour), "expires_at": now.Add(-time.Hour)},
+		bson.M{"_id": purged, "data": "", "deleted_at": now.Add(-2 * time.Hour)},
+		bson.M{"_id": retained, "data": "", "deleted_at": now.Add(-time.Minute)},
+	})
+	require.Nil(ss.T(), err)
+
+	result, err := store.Sweep(context.Background())
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), result.Expired >= 1)
+	assert.True(ss.T(), result.Tombstones >= 1)
+	assert.Equal(ss.T(), int64(0), result.Untimestamped)
+
+	count, err := ss.collection.CountDocuments(context.Background(), bson.M{"_id": bson.M{"$in": bson.A{expired, purged}}})
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(0), count)
+	count, err = ss.collection.CountDocuments(context.Background(), bson.M{"_id": retained})
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(1), count)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_SoftDelete_SkipUnchangedWrites() {
+	store := *ss.store
+	store.storeOptions.SoftDelete = SoftDeleteOptions{Enabled: true, RetainFor: time.Hour}
+	store.storeOptions.SkipUnchangedWrites = true
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	_, err = store.Delete(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	var tombstone session
+	require.Nil(ss.T(), ss.collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&tombstone))
+	assert.Empty(ss.T(), tombstone.ValuesHash)
+
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	loaded, err := store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew, "saving unchanged values should replace the tombstone rather than touch it")
+	assert.Equal(ss.T(), "abc", loaded.Values["user_id"])
+}
+
+func (ss *SaveSuite) TestMongoDBStore_CollectionFromContext() {
+	type tenantKey struct{}
+	tenantCollection := ss.collection.Database().Collection(TEST_COLLECTION + "_tenant")
+	defer tenantCollection.Drop(context.Background())
+
+	store := *ss.store
+	store.storeOptions.CollectionFromContext = func(ctx context.Context) (*mongo.Collection, error) {
+		if ctx.Value(tenantKey{}) == nil {
+			return nil, errors.New("no tenant")
+		}
+		return tenantCollection, nil
+	}
+
+	r := (&http.Request{}).WithContext(context.WithValue(context.Background(), tenantKey{}, "acme"))
+	sess, err := store.New(r, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), store.Save(r, nil, sess))
+
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	count, err := tenantCollection.CountDocuments(context.Background(), bson.M{"_id": oid})
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(1), count)
+	count, err = ss.collection.CountDocuments(context.Background(), bson.M{"_id": oid})
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(0), count)
+
+	assert.NotNil(ss.T(), store.Save(&http.Request{}, nil, sess), "a failing resolver should fail the save")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_UpdateValues() {
+	sess, err := ss.store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	sess.Values["role"] = "admin"
+	require.Nil(ss.T(), ss.store.Save(&http.Request{}, nil, sess))
+
+	err = ss.store.UpdateValues(context.Background(), "session-key", sess.ID, func(values map[interface{}]interface{}) error {
+		delete(values, "role")
+		values["revoked"] = true
+		return nil
+	})
+	require.Nil(ss.T(), err)
+
+	loaded, err := ss.store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), map[interface{}]interface{}{"user_id": "abc", "revoked": true}, loaded.Values)
+
+	mutateErr := errors.New("refused")
+	err = ss.store.UpdateValues(context.Background(), "session-key", sess.ID, func(map[interface{}]interface{}) error {
+		return mutateErr
+	})
+	assert.Equal(ss.T(), mutateErr, err)
+
+	err = ss.store.UpdateValues(context.Background(), "session-key", primitive.NewObjectID().Hex(),
+		func(map[interface{}]interface{}) error { return nil })
+	assert.Equal(ss.T(), ErrSessionNotFound, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_DeleteSessionsModifiedBefore() {
+	store := *ss.store
+	now := time.Now().UTC()
+	store.storeOptions.Clock = func() time.Time { return now.Add(-time.Hour) }
+	before, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, before))
+
+	store.storeOptions.Clock = func() time.Time { return now }
+	after, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, after))
+
+	deleted, err := store.DeleteSessionsModifiedBefore(context.Background(), now.Add(-time.Minute))
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(1), deleted)
+
+	loaded, err := store.NewWithID(&http.Request{}, "session-key", before.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), loaded.IsNew)
+	loaded, err = store.NewWithID(&http.Request{}, "session-key", after.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_SaveWithOptions() {
+	r := &http.Request{}
+	sess, err := ss.store.New(r, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), ss.store.Save(r, nil, sess))
+
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	_, err = ss.collection.DeleteOne(context.Background(), bson.M{"_id": oid})
+	require.Nil(ss.T(), err)
+
+	durable := SaveOptions{WriteConcern: writeconcern.New(writeconcern.WMajority(), writeconcern.J(true))}
+	require.Nil(ss.T(), ss.store.SaveWithOptions(r, nil, sess, durable))
+	count, err := ss.collection.CountDocuments(context.Background(), bson.M{"_id": oid})
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(1), count, "a save with a write concern override should not be coalesced")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_SaveWithResult() {
+	sess, err := ss.store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+
+	result, err := ss.store.SaveWithResult(&http.Request{}, nil, sess)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), SaveCreated, result)
+
+	sess.Values["user_id"] = "def"
+	result, err = ss.store.SaveWithResult(&http.Request{}, nil, sess)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), SaveUpdated, result)
+
+	sess.Options.MaxAge = -1
+	result, err = ss.store.SaveWithResult(&http.Request{}, nil, sess)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), SaveDeleted, result)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Query() {
+	store := *ss.store
+	store.storeOptions.ExtraFields = func(sess *sessions.Session) bson.M {
+		return bson.M{"tenant": sess.Values["tenant"]}
+	}
+
+	var acme []string
+	for _, tenant := range []string{"query-acme", "query-acme", "query-globex"} {
+		sess, err := store.New(&http.Request{}, "session-key")
+		require.Nil(ss.T(), err)
+		sess.Values["tenant"] = tenant
+		require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+		if tenant == "query-acme" {
+			acme = append(acme, sess.ID)
+		}
+	}
+
+	infos, err := store.Query(context.Background(), bson.M{"tenant": "query-acme"})
+	require.Nil(ss.T(), err)
+	var ids []string
+	for _, info := range infos {
+		ids = append(ids, info.ID)
+	}
+	assert.ElementsMatch(ss.T(), acme, ids)
+
+	infos, err = store.Query(context.Background(), bson.M{"tenant": "query-acme"}, options.Find().SetLimit(1))
+	require.Nil(ss.T(), err)
+	assert.Len(ss.T(), infos, 1)
+
+	count, err := store.CountByFilter(context.Background(), bson.M{"tenant": "query-acme"})
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(len(acme)), count)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_IDPrefix() {
+	app1 := *ss.store
+	app1.storeOptions.IDPrefix = "app1"
+	app2 := *ss.store
+	app2.storeOptions.IDPrefix = "app2"
+
+	sess, err := app1.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), app1.Save(&http.Request{}, nil, sess))
+	var stored bson.M
+	require.Nil(ss.T(), ss.store.collection.FindOne(context.Background(), bson.M{"_id": "app1:" + sess.ID}).Decode(&stored))
+	assert.Equal(ss.T(), "session-key", stored["name"])
+	other, err := app2.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), app2.Save(&http.Request{}, nil, other))
+
+	loaded, err := app2.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), loaded.IsNew, "a session should not load outside of its namespace")
+
+	deleted, err := app2.DeleteByPrefix(context.Background(), "app1")
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(1), deleted)
+	exists, err := app2.SessionExists(context.Background(), other.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), exists)
+
+	_, err = app2.DeleteByPrefix(context.Background(), "")
+	assert.Equal(ss.T(), ErrEmptyIDPrefix, err)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_ForceExpire() {
+	store := *ss.store
+	store.storeOptions.TTLOptions.EnforceOnRead = true
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+
+	require.Nil(ss.T(), store.ForceExpire(context.Background(), "session-key", sess.ID))
+	exists, err := store.SessionExists(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), exists, "an expired session should not be deleted right away")
+
+	store.storeOptions.Clock = func() time.Time { return time.Now().Add(time.Second) }
+	loaded, err := store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.True(ss.T(), loaded.IsNew)
+
+	assert.Equal(ss.T(), ErrSessionNotFound, store.ForceExpire(context.Background(), "session-key", primitive.NewObjectID().Hex()))
+	assert.Equal(ss.T(), ErrSessionNotFound, store.ForceExpire(context.Background(), "other-key", sess.ID))
+}
+
+func (ss *SaveSuite) TestMongoDBStore_ForceExpire_Tombstone() {
+	store := *ss.store
+	store.storeOptions.SoftDelete = SoftDeleteOptions{Enabled: true, RetainFor: time.Hour}
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	_, err = store.Delete(context.Background(), sess.ID)
+	require.Nil(ss.T(), err)
+
+	assert.Equal(ss.T(), ErrSessionNotFound, store.ForceExpire(context.Background(), "session-key", sess.ID))
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	var tombstone session
+	require.Nil(ss.T(), ss.collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&tombstone))
+	assert.True(ss.T(), tombstone.LastModified.IsZero(), "the tombstone should be left to SoftDelete.RetainFor")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_SaveWithCookieOptions() {
+	r := &http.Request{}
+	sess, err := ss.store.New(r, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	storedMaxAge := sess.Options.MaxAge
+
+	w := NewMockResponseWriter()
+	require.Nil(ss.T(), ss.store.SaveWithCookieOptions(r, w, sess, &sessions.Options{Path: "/", MaxAge: 0}))
+	cookies := (&http.Response{Header: w.Header()}).Cookies()
+	require.Len(ss.T(), cookies, 1)
+	assert.Equal(ss.T(), 0, cookies[0].MaxAge, "the cookie should be a session cookie")
+	assert.True(ss.T(), cookies[0].Expires.IsZero())
+	assert.Equal(ss.T(), storedMaxAge, sess.Options.MaxAge, "sess.Options should be untouched")
+
+	loaded, err := ss.store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_RepairMissingTimestamps() {
+	createdAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+	withCreatedAt := primitive.NewObjectID()
+	without := primitive.NewObjectID()
+	_, err := ss.collection.InsertMany(context.Background(), []interface{}{
+		bson.M{"_id": withCreatedAt, "data": "", "created_at": createdAt},
+		bson.M{"_id": without, "data": ""},
+	})
+	require.Nil(ss.T(), err)
+
+	repaired, err := ss.store.RepairMissingTimestamps(context.Background())
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(2), repaired)
+
+	var s session
+	require.Nil(ss.T(), ss.collection.FindOne(context.Background(), bson.M{"_id": withCreatedAt}).Decode(&s))
+	assert.Equal(ss.T(), createdAt, s.LastModified)
+	require.Nil(ss.T(), ss.collection.FindOne(context.Background(), bson.M{"_id": without}).Decode(&s))
+	assert.False(ss.T(), s.LastModified.IsZero())
+
+	repaired, err = ss.store.RepairMissingTimestamps(context.Background())
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(0), repaired)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_RepairMissingTimestamps_Legacy() {
+	legacyStore := *ss.store
+	legacyStore.storeOptions.LegacyOptions = LegacyOptions{Enabled: true, DataField: "session_data"}
+
+	legacy := primitive.NewObjectID()
+	withValues := primitive.NewObjectID()
+	_, err := ss.collection.InsertMany(context.Background(), []interface{}{
+		bson.M{"_id": legacy, "session_data": `{"user":"gopher"}`},
+		bson.M{"_id": withValues, "data": "", "values": bson.M{"user": "gopher"}},
+	})
+	require.Nil(ss.T(), err)
+
+	repaired, err := legacyStore.RepairMissingTimestamps(context.Background())
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(1), repaired)
+
+	sess := sessions.NewSession(&legacyStore, "key")
+	sess.ID = legacy.Hex()
+	sess.Options = legacyStore.defaultOptions
+	require.Nil(ss.T(), legacyStore.load(context.Background(), sess))
+	assert.Equal(ss.T(), "gopher", sess.Values["user"], "the legacy document should still load as legacy")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_DeleteUntimestampedSessions() {
+	cutoff := time.Now().Add(-time.Hour)
+	old := primitive.NewObjectIDFromTimestamp(cutoff.Add(-time.Hour))
+	recent := primitive.NewObjectID()
+	timestamped := primitive.NewObjectIDFromTimestamp(cutoff.Add(-time.Hour))
+	_, err := ss.collection.InsertMany(context.Background(), []interface{}{
+		bson.M{"_id": old, "data": ""},
+		bson.M{"_id": recent, "data": ""},
+		bson.M{"_id": timestamped, "data": "", "last_modified": time.Now()},
+	})
+	require.Nil(ss.T(), err)
+
+	deleted, err := ss.store.DeleteUntimestampedSessions(context.Background(), cutoff)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(1), deleted)
+
+	count, err := ss.collection.CountDocuments(context.Background(), bson.M{"_id": bson.M{"$in": bson.A{recent, timestamped}}})
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), int64(2), count)
+}
+
+func (ss *SaveSuite) TestMongoDBStore_PartitionedCookies() {
+	store := *ss.store
+	store.storeOptions.PartitionedCookies = true
+	r := &http.Request{}
+	sess, err := store.New(r, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Options.Secure = true
+	sess.Options.SameSite = http.SameSiteNoneMode
+
+	w := NewMockResponseWriter()
+	require.Nil(ss.T(), store.Save(r, w, sess))
+	setCookie := w.Header().Get("Set-Cookie")
+	assert.True(ss.T(), strings.HasSuffix(setCookie, "; Secure; SameSite=None; Partitioned"), setCookie)
+	assert.Equal(ss.T(), 1, strings.Count(setCookie, "Partitioned"))
+
+	sess.Options.MaxAge = -1
+	w = NewMockResponseWriter()
+	require.Nil(ss.T(), store.Save(r, w, sess))
+	assert.Contains(ss.T(), w.Header().Get("Set-Cookie"), "; Partitioned")
+}
+
+func (ss *SaveSuite) TestMongoDBStore_Cache() {
+	store := *ss.store
+	store.storeOptions.Cache = NewLRUCache(16, time.Minute)
+
+	sess, err := store.New(&http.Request{}, "session-key")
+	require.Nil(ss.T(), err)
+	sess.Values["user_id"] = "abc"
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	_, err = store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	require.Nil(ss.T(), err)
+	_, err = ss.collection.DeleteOne(context.Background(), bson.M{"_id": oid})
+	require.Nil(ss.T(), err)
+	loaded, err := store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.False(ss.T(), loaded.IsNew, "session should be served from the cache")
+
+	sess.Values["user_id"] = "def"
+	require.Nil(ss.T(), store.Save(&http.Request{}, nil, sess))
+	loaded, err = store.NewWithID(&http.Request{}, "session-key", sess.ID)
+	require.Nil(ss.T(), err)
+	assert.Equal(ss.T(), "def", loaded.Values["user_id"])
+}
+
+func assertSessionStoredProperlyInCookie(
+	t *testing.T,
+	sessionKey string,
+	session *sessions.Session,
+	store *MongoDBStore,
+	rw http.ResponseWriter,
+) {
+	encodedSession, err := securecookie.EncodeMulti(sessionKey, session.ID, store.codecs...)
+	require.Nil(t, err)
+	expectedCookieString := sessions.NewCookie(sessionKey, encodedSession, store.defaultOptions).String()
+	cookieString := rw.Header().Get("Set-Cookie")
+	assert.Equal(t, expectedCookieString, cookieString)
+}
+
+func assertSessionStoredProperlyInDB(t *testing.T, expectedSession *sessions.Session, store *MongoDBStore) {
+	s := sessions.NewSession(store, expectedSession.Name())
+	s.Options = store.defaultOptions
+	s.ID = expectedSession.ID
+	err := store.load(context.Background(), s)
+	require.Nil(t, err)
+
+	assert.Equal(t, expectedSession, s)
+}
+
+func TestMongoDBStore_EncodeDecodeValues(t *testing.T) {
+	store := &MongoDBStore{codecs: securecookie.CodecsFromPairs([]byte("abcdefghijklmnop"))}
+	values := map[interface{}]interface{}{"key": "value", "i": 5}
+	data, err := store.EncodeValues("name", values)
+	require.Nil(t, err)
+
+	decoded, err := store.DecodeValues("name", data)
+	assert.Nil(t, err)
+	assert.Equal(t, values, decoded)
+
+	_, err = store.DecodeValues("other", data)
+	assert.NotNil(t, err)
+}
+
+//jsonValueSerializer stores values with string keys as JSON
+type jsonValueSerializer struct{}
+
+func (jsonValueSerializer) Serialize(values map[interface{}]interface{}) ([]byte, error) {
+	stringKeyed := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		stringKeyed[k.(string)] = v
+	}
+	return json.Marshal(stringKeyed)
+}
+
+func (jsonValueSerializer) Deserialize(data []byte) (map[interface{}]interface{}, error) {
+	var stringKeyed map[string]interface{}
+	if err := json.Unmarshal(data, &stringKeyed); err != nil {
+		return nil, err
+	}
+	values := make(map[interface{}]interface{}, len(stringKeyed))
+	for k, v := range stringKeyed {
+		values[k] = v
+	}
+	return values, nil
+}
+
+func TestMongoDBStore_ValueSerializer(t *testing.T) {
+	store := &MongoDBStore{
+		codecs:       securecookie.CodecsFromPairs([]byte("abcdefghijklmnop")),
+		storeOptions: Options{ValueSerializer: jsonValueSerializer{}},
+	}
+	sess := sessions.NewSession(store, "name")
+	sess.ID = primitive.NewObjectID().Hex()
+	sess.Values["key"] = "value"
+
+	s, err := store.toDocument(sess)
+	require.Nil(t, err)
+	loaded := sessions.NewSession(store, "name")
+	require.Nil(t, store.decodeValues(loaded, s))
+	assert.Equal(t, map[interface{}]interface{}{"key": "value"}, loaded.Values)
+
+	gobStore := &MongoDBStore{codecs: store.codecs}
+	_, err = gobStore.DecodeValues("name", s.Data)
+	assert.NotNil(t, err, "JSON serialized values should not decode as gob")
+}
+
+func TestMongoDBStore_idFilter(t *testing.T) {
+	oid := primitive.NewObjectID()
+	store := &MongoDBStore{storeOptions: Options{
+		BaseFilter: bson.M{"tenant": "acme"},
+		ShardKeyFunc: func(sessionID string) bson.M {
+			return bson.M{"shard": sessionID[len(sessionID)-2:], "tenant": "other"}
+		},
+	}}
+
+	assert.Equal(t, bson.M{
+		"_id":    documentID{oid: oid},
+		"tenant": "acme",
+		"shard":  oid.Hex()[22:],
+	}, store.idFilter(oid))
+}
+
+func TestNewMongoDBStore_NilCollection(t *testing.T) {
+	storeOptions := Options{TTLOptions: TTLOptions{TTL: time.Minute}}
+
+	_, err := NewMongoDBStore(nil, storeOptions, nil, nil)
+	assert.Equal(t, ErrNilCollection, err)
+	_, err = NewMongoDBStore(&mongo.Collection{}, storeOptions, nil, nil)
+	assert.Equal(t, ErrNilCollection, err)
+}
+
+func TestMongoDBStore_expiresAt(t *testing.T) {
+	store := &MongoDBStore{
+		ttl:          time.Hour,
+		storeOptions: Options{TTLOptions: TTLOptions{Jitter: time.Minute}},
+	}
+	now := time.Now()
+
+	spread := make(map[time.Time]bool)
+	for i := 0; i < 100; i++ {
+		expiresAt := store.expiresAt("key", now)
+		assert.False(t, expiresAt.Before(now.Add(59*time.Minute)))
+		assert.False(t, expiresAt.After(now.Add(61*time.Minute)))
+		spread[expiresAt] = true
+	}
+	assert.True(t, len(spread) > 1)
+
+	store.storeOptions.TTLOptions.Jitter = 0
+	assert.Equal(t, now.Add(time.Hour), store.expiresAt("key", now))
+}
+
+func TestMongoDBStore_Codecs(t *testing.T) {
+	store := &MongoDBStore{codecs: securecookie.CodecsFromPairs([]byte("secret-key"))}
+
+	codecs := store.Codecs()
+	codecs[0] = nil
+	assert.NotNil(t, store.codecs[0])
+}
+
+func TestLayoutSubdocument_UnsupportedMethods(t *testing.T) {
+	store := &MongoDBStore{storeOptions: Options{Layout: LayoutSubdocument}}
+	ctx := context.Background()
+	sessionID := primitive.NewObjectID().Hex()
+
+	err := store.SaveAll(ctx, []*sessions.Session{sessions.NewSession(store, "key")})
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+	_, err = store.ImportSession(ctx, []byte(`{}`))
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+	_, err = store.MigrateFormat(ctx, "key", SerializationBSON)
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+	_, err = store.LoadAndTouch(ctx, "key", sessionID)
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+	err = store.UpdateValues(ctx, "key", sessionID, func(map[interface{}]interface{}) error { return nil })
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+}
+
+func TestCappedOptions_UnsupportedInPlaceUpdates(t *testing.T) {
+	capped := CappedOptions{Enabled: true, SizeBytes: 1 << 20}
+	err := Options{
+		TTLOptions:    TTLOptions{TTL: time.Minute},
+		CappedOptions: capped,
+		SoftDelete:    SoftDeleteOptions{Enabled: true},
+	}.Validate()
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+
+	store := &MongoDBStore{storeOptions: Options{CappedOptions: capped}}
+	ctx := context.Background()
+	err = store.SaveAll(ctx, []*sessions.Session{sessions.NewSession(store, "key")})
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+	_, err = store.MigrateFormat(ctx, "key", SerializationBSON)
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+	err = store.UpdateValues(ctx, "key", primitive.NewObjectID().Hex(), func(map[interface{}]interface{}) error { return nil })
+	assert.IsType(t, &IncompatibleOptionsErr{}, err)
+}
+
+func TestPingReadPref(t *testing.T) {
+	assert.Equal(t, readpref.PrimaryMode, pingReadPref(true).Mode())
+	assert.Equal(t, readpref.PrimaryPreferredMode, pingReadPref(false).Mode())
+}
+
+func TestTTLIndexState_Concurrent(t *testing.T) {
+	store := &MongoDBStore{ttlIndex: newTTLIndexState(false)}
+	derived := store.clone()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			store.ttlIndex.setEnsured(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_ = derived.TTLIndexEnsured()
+	}
+	<-done
+
+	store.ttlIndex.setEnsured(true)
+	assert.True(t, derived.TTLIndexEnsured())
+	assert.False(t, (&MongoDBStore{}).TTLIndexEnsured())
+}
+
+func TestMetadataProjection(t *testing.T) {
+	assert.Equal(t, bson.M{"data": 0, "values": 0}, metadataProjection())
+}
+
+func TestOptions_ValidateIDPrefix(t *testing.T) {
+	err := Options{TTLOptions: TTLOptions{TTL: time.Minute}, IDPrefix: "a:b"}.Validate()
+	assert.IsType(t, &InvalidIDPrefixErr{}, err)
+	assert.Nil(t, Options{TTLOptions: TTLOptions{TTL: time.Minute}, IDPrefix: "app1"}.Validate())
+}
+
+func TestMongoDBStore_ScopedFilterIDPrefix(t *testing.T) {
+	store := &MongoDBStore{storeOptions: Options{IDPrefix: "app.1"}}
+	oid := primitive.NewObjectID()
+	filter := store.scopedFilter(bson.M{"_id": bson.M{"$lt": store.documentID(oid)}})
+	idFilter, ok := filter["_id"].(bson.M)
+	require.True(t, ok)
+	assert.Equal(t, documentID{prefix: "app.1", oid: oid}, idFilter["$lt"])
+	assert.Equal(t, `^app\.1:`, idFilter["$regex"])
+
+	_, err := store.DeleteByPrefix(context.Background(), "a:b")
+	assert.IsType(t, &InvalidIDPrefixErr{}, err)
+}
+
+func TestIsUnauthorizedError(t *testing.T) {
+	assert.True(t, isUnauthorizedError(mongo.CommandError{Code: 13, Name: "Unauthorized"}))
+	assert.True(t, isUnauthorizedError(fmt.Errorf("creating index: %w", mongo.CommandError{Code: 13})))
+	assert.False(t, isUnauthorizedError(mongo.CommandError{Code: 85, Name: "IndexOptionsConflict"}))
+	assert.False(t, isUnauthorizedError(errors.New("connection refused")))
+}
+
+func TestDefaultFingerprint(t *testing.T) {
+	request := func(remoteAddr, userAgent string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("User-Agent", userAgent)
+		return r
+	}
+
+	base := DefaultFingerprint(request("203.0.113.7:4321", "browser"))
+	assert.Equal(t, base, DefaultFingerprint(request("203.0.113.200:80", "browser")))
+	assert.NotEqual(t, base, DefaultFingerprint(request("203.0.114.7:4321", "browser")))
+	assert.NotEqual(t, base, DefaultFingerprint(request("203.0.113.7:4321", "other")))
+	assert.Equal(t,
+		DefaultFingerprint(request("[2001:db8::1]:443", "browser")),
+		DefaultFingerprint(request("[2001:db8::ffff]:443", "browser")),
+	)
+}
+
+func TestMongoDBStore_New_SameRequestWithoutCookie(t *testing.T) {
+	store := &MongoDBStore{defaultOptions: &sessions.Options{Path: "/"}}
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	first, err := store.New(r, "key")
+	require.Nil(t, err)
+	again, err := store.New(r, "key")
+	require.Nil(t, err)
+	assert.Same(t, first, again)
+
+	other, err := store.New(r, "other")
+	require.Nil(t, err)
+	assert.NotEqual(t, first.ID, other.ID)
+
+	elsewhere, err := store.New(&http.Request{}, "key")
+	require.Nil(t, err)
+	assert.NotEqual(t, first.ID, elsewhere.ID)
+}
+
+func TestMongoDBStore_DecodeID(t *testing.T) {
+	store := &MongoDBStore{codecs: securecookie.CodecsFromPairs([]byte("secret-key"))}
+	request := func(value string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		if value != "" {
+			r.AddCookie(&http.Cookie{Name: "key", Value: value})
+		}
+		return r
+	}
+
+	_, err := store.DecodeID(request(""), "key")
+	assert.Equal(t, ErrNoSessionID, err)
+
+	_, err = store.DecodeID(request("tampered"), "key")
+	var invalidCookieErr *InvalidCookieErr
+	assert.True(t, errors.As(err, &invalidCookieErr))
+
+	sessionID := primitive.NewObjectID().Hex()
+	encodedID, err := store.idCodec().Encode("key", sessionID)
+	require.Nil(t, err)
+	decodedID, err := store.DecodeID(request(encodedID), "key")
+	require.Nil(t, err)
+	assert.Equal(t, sessionID, decodedID)
+
+	_, err = store.DecodeID(request(encodedID), "other")
+	assert.Equal(t, ErrNoSessionID, err)
+}
+
+func TestMongoDBStore_CookieNameMismatch(t *testing.T) {
+	store := &MongoDBStore{
+		codecs:         securecookie.CodecsFromPairs([]byte("secret-key")),
+		defaultOptions: &sessions.Options{Path: "/"},
+		logger:         log.NewNopLogger(),
+	}
+	encodedForOther, err := store.idCodec().Encode("other", primitive.NewObjectID().Hex())
+	require.Nil(t, err)
+
+	swapped, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	swapped.AddCookie(&http.Cookie{Name: "key", Value: encodedForOther})
+	swapped.AddCookie(&http.Cookie{Name: "other", Value: encodedForOther})
+	_, err = store.DecodeID(swapped, "key")
+	assert.True(t, errors.Is(err, ErrCookieNameMismatch))
+	_, err = store.New(swapped, "key")
+	assert.True(t, errors.Is(err, ErrCookieNameMismatch))
+
+	tampered, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	tampered.AddCookie(&http.Cookie{Name: "key", Value: "tampered"})
+	tampered.AddCookie(&http.Cookie{Name: "other", Value: encodedForOther})
+	_, err = store.DecodeID(tampered, "key")
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrCookieNameMismatch))
+}
+
+func TestMongoDBStore_isExpired(t *testing.T) {
+	store := &MongoDBStore{
+		ttl: time.Minute,
+		storeOptions: Options{TTLOptions: TTLOptions{
+			EnforceOnRead: true,
+			GracePeriod:   time.Minute,
+			TTLByName:     map[string]time.Duration{"long": time.Hour},
+		}},
+	}
+	now := time.Now().UTC()
+
+	assert.False(t, store.isExpired(session{LastModified: now}, "key"))
+	assert.False(t, store.isExpired(session{LastModified: now.Add(-90 * time.Second)}, "key"))
+	assert.True(t, store.isExpired(session{LastModified: now.Add(-3 * time.Minute)}, "key"))
+	assert.False(t, store.isExpired(session{LastModified: now.Add(-3 * time.Minute)}, "long"))
+	assert.False(t, store.isExpired(session{}, "key"))
+	assert.True(t, store.isExpired(session{LastModified: now, ExpiresAt: now.Add(-2 * time.Minute)}, "key"))
+
+	store.storeOptions.TTLOptions.ClockSkew = 2 * time.Minute
+	assert.False(t, store.isExpired(session{LastModified: now.Add(-3 * time.Minute)}, "key"))
+	assert.True(t, store.isExpired(session{LastModified: now.Add(-5 * time.Minute)}, "key"))
+	store.storeOptions.TTLOptions.ClockSkew = 0
+
+	store.storeOptions.Clock = func() time.Time { return now.Add(-time.Hour) }
+	assert.False(t, store.isExpired(session{LastModified: now.Add(-3 * time.Minute)}, "key"))
+}
+
+//countingCache counts the loads that missed the cache and went to the collection
+type countingCache struct {
+	Cache
+	misses int
+}
+
+func (c *countingCache) Get(sessionID string) ([]byte, bool) {
+	document, ok := c.Cache.Get(sessionID)
+	if !ok {
+		c.misses++
+	}
+	return document, ok
+}
+
+func BenchmarkMongoDBStore_Load(b *testing.B) {
+	mongoHost, exists := os.LookupEnv(MONGO_HOST)
+	if !exists {
+		mongoHost = "localhost:27017"
+	}
+	client, err := mongo.NewClient(options.Client().SetHosts([]string{mongoHost}).SetConnectTimeout(5 * time.Second))
+	require.Nil(b, err)
+	require.Nil(b, client.Connect(context.Background()))
+	defer client.Disconnect(context.Background())
+	collection := client.Database(TEST_DATABASE).Collection(TEST_COLLECTION + "_bench")
+	defer collection.Drop(context.Background())
+
+	for _, cached := range []bool{false, true} {
+		cache := &countingCache{Cache: NewLRUCache(1024, time.Second)}
+		storeOptions := Options{TTLOptions: TTLOptions{TTL: time.Minute}}
+		if cached {
+			storeOptions.Cache = cache
+		}
+		store, err := NewMongoDBStore(collection, storeOptions, nil, nil,
+			securecookie.CodecsFromPairs([]byte("abcdefghijklmnop"))...)
+		require.Nil(b, err)
+		sess, err := store.New(&http.Request{}, "session-key")
+		require.Nil(b, err)
+		sess.Values["user_id"] = "abc"
+		require.Nil(b, store.Save(&http.Request{}, nil, sess))
+
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			cache.misses = 0
+			for i := 0; i < b.N; i++ {
+				if _, err := store.NewWithID(&http.Request{}, "session-key", sess.ID); err != nil {
+					b.Fatal(err)
+				}
+			}
+			reads := b.N
+			if cached {
+				reads = cache.misses
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func BenchmarkMongoDBStore_Save(b *testing.B) {
+	mongoHost, exists := os.LookupEnv(MONGO_HOST)
+	if !exists {
+		mongoHost = "localhost:27017"
+	}
+	client, err := mongo.NewClient(options.Client().SetHosts([]string{mongoHost}).SetConnectTimeout(5 * time.Second))
+	require.Nil(b, err)
+	require.Nil(b, client.Connect(context.Background()))
+	defer client.Disconnect(context.Background())
+	collection := client.Database(TEST_DATABASE).Collection(TEST_COLLECTION + "_bench")
+	defer collection.Drop(context.Background())
+
+	store, err := NewMongoDBStore(collection, Options{TTLOptions: TTLOptions{TTL: time.Minute}}, nil, nil,
+		securecookie.CodecsFromPairs([]byte("abcdefghijklmnop"))...)
+	require.Nil(b, err)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		sess, err := store.New(&http.Request{}, "session-key")
+		if err != nil {
+			b.Fatal(err)
+		}
+		i := 0
+		for pb.Next() {
+			sess.Values["counter"] = i
+			if err := store.Save(&http.Request{}, nil, sess); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMarshalBSONValues(b *testing.B) {
+	values := map[interface{}]interface{}{
+		"user_id": "abc",
+		"roles":   []string{"admin", "user"},
+		"visits":  42,
+		"cart":    strings.Repeat("item,", 200),
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := marshalBSONValues(values, bson.DefaultRegistry); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeData(b *testing.B) {
+	values := map[interface{}]interface{}{
+		"user_id": "abc",
+		"roles":   []string{"admin", "user"},
+		"visits":  42,
+	}
+	codecs := securecookie.CodecsFromPairs([]byte("abcdefghijklmnop"))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := encodeData("session-key", values, nil, codecs...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHashValues(b *testing.B) {
+	values := map[interface{}]interface{}{
+		"user_id": "abc",
+		"roles":   []string{"admin", "user"},
+		"visits":  42,
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := hashValues(values); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
@@ -0,0 +1,40 @@
+package sessions_mongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//collectionFor returns the collection the sessions of the request carrying ctx are stored
+//in: the result of Options.CollectionFromContext, or the store's collection without one,
+//with the write concern of a SaveWithOptions call applied
+func (store *MongoDBStore) collectionFor(ctx context.Context) (collection, error) {
+	c, err := store.resolveCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if wc := writeConcernOverride(ctx); wc != nil {
+		return c.Clone(options.Collection().SetWriteConcern(wc))
+	}
+
+	return c, nil
+}
+
+func (store *MongoDBStore) resolveCollection(ctx context.Context) (collection, error) {
+	if store.storeOptions.CollectionFromContext == nil {
+		return store.collection, nil
+	}
+
+	resolved, err := store.storeOptions.CollectionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == nil || resolved.Database() == nil || resolved.Database().Client() == nil {
+		return nil, ErrNilCollection
+	}
+	if collectionOpts := store.storeOptions.collectionOptions(); collectionOpts != nil {
+		return resolved.Clone(collectionOpts)
+	}
+
+	return resolved, nil
+}
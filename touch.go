@@ -0,0 +1,161 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+)
+
+//LoadAndTouch loads the session stored under sessionID and refreshes its last_modified
+//and expires_at in a single round trip, for sliding idle timeouts.  The session name is
+//required because it is bound into the encoded values by the codecs and determines the
+//TTL.  A missing or soft deleted session, or with TTLOptions.EnforceOnRead an expired
+//one, returns ErrSessionNotFound and is not touched.  Documents read through LegacyOptions
+//are loaded without being touched, as touching them would stop them being recognized as
+//legacy.  The returned session carries the store's default options.  LoadAndTouch is not
+//supported with LayoutSubdocument.
+func (store *MongoDBStore) LoadAndTouch(ctx context.Context, name, sessionID string) (*sessions.Session, error) {
+	if store.storeOptions.Layout == LayoutSubdocument {
+		return nil, NewIncompatibleOptionsErr("LoadAndTouch is not supported with LayoutSubdocument")
+	}
+
+	oid, err := parseSessionID(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := store.excludeLegacy(store.excludeTombstones(store.namedFilter(oid, name)))
+	if store.storeOptions.TTLOptions.EnforceOnRead {
+		filter["$or"] = store.unexpiredFilter(name)
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if store.storeOptions.LoadProjection != nil {
+		opts.SetProjection(store.storeOptions.LoadProjection)
+	}
+
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var s session
+	err = store.withRetry(ctx, func() error {
+		return c.FindOneAndUpdate(ctx, filter, store.touchUpdate(name), opts).Decode(&s)
+	})
+	store.uncache(sessionID)
+	if err == mongo.ErrNoDocuments && store.storeOptions.LegacyOptions.Enabled {
+		return store.loadUntouched(ctx, name, sessionID)
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		_ = level.Error(store.contextLogger(ctx)).Log(
+			"message", "failed to load and touch session",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return nil, err
+	}
+	store.cacheSession(s)
+
+	sess := sessions.NewSession(store, name)
+	sess.ID = sessionID
+	sess.Options = derefOpts(store.defaultOptions)
+	if err = store.decodeValues(sess, s); err != nil {
+		_ = level.Error(store.contextLogger(ctx)).Log(
+			"message", "failed to decode stored session values",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+//loadUntouched loads the session for LoadAndTouch when the touch matched nothing, as it
+//does for legacy documents
+func (store *MongoDBStore) loadUntouched(ctx context.Context, name, sessionID string) (*sessions.Session, error) {
+	sess := sessions.NewSession(store, name)
+	sess.ID = sessionID
+	sess.Options = derefOpts(store.defaultOptions)
+	err := store.load(ctx, sess)
+	if err == mongo.ErrNoDocuments || err == errSessionReset {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+//unexpiredFilter is the query equivalent of isExpired returning false for a session
+//named name, for use as the value of `$or`
+func (store *MongoDBStore) unexpiredFilter(name string) bson.A {
+	ttlOptions := store.storeOptions.TTLOptions
+	cutoff := store.now().Add(-ttlOptions.GracePeriod - ttlOptions.ClockSkew)
+
+	return bson.A{
+		bson.M{"expires_at": bson.M{"$gte": cutoff}},
+		bson.M{
+			"expires_at":    bson.M{"$exists": false},
+			"last_modified": bson.M{"$gte": cutoff.Add(-store.ttlFor(name))},
+		},
+		bson.M{
+			"expires_at":    bson.M{"$exists": false},
+			"last_modified": bson.M{"$exists": false},
+		},
+	}
+}
+
+//refreshLoaded extends the life of a session New or NewWithID loaded for r, per
+//TTLOptions.RefreshOnLoad.  The refresh counts as a save during the request, so a Save of
+//the unchanged session later in the request doesn't write it again.
+func (store *MongoDBStore) refreshLoaded(r *http.Request, sess *sessions.Session) {
+	if !store.storeOptions.TTLOptions.RefreshOnLoad {
+		return
+	}
+
+	oid, err := primitive.ObjectIDFromHex(sess.ID)
+	if err != nil {
+		return
+	}
+
+	ctx := r.Context()
+	c, err := store.collectionFor(ctx)
+	var res *mongo.UpdateResult
+	if err == nil {
+		filter := store.excludeLegacy(store.namedFilter(oid, sess.Name()))
+		err = store.withRetry(ctx, func() error {
+			var err error
+			res, err = c.UpdateOne(ctx, filter, store.touchUpdate(sess.Name()))
+			return err
+		})
+	}
+	store.uncache(sess.ID)
+	if isUnacknowledgedWrite(err) {
+		err = nil
+	} else if err == nil && res.MatchedCount == 0 {
+		//a legacy document isn't refreshed, and the next Save rewrites it in the current format
+		return
+	}
+	if err != nil {
+		_ = level.Warn(store.contextLogger(ctx)).Log(
+			"message", "failed to refresh loaded session",
+			"session_id", sess.ID,
+			"error", err,
+		)
+		return
+	}
+
+	if saves := store.requestSaves(r); saves != nil {
+		saves.record(sess, store.contentHash(sess))
+	}
+}
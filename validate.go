@@ -0,0 +1,124 @@
+package sessions_mongo
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/sessions"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+)
+
+//DecodeID returns the ID of the session called name the client of r sent, read like New
+//does, without touching the datastore, e.g. for correlating logs or rate limiting by
+//session before the session is loaded.  It returns ErrNoSessionID if r carries no session
+//ID and an *InvalidCookieErr if it cannot be decoded.  The session may not exist anymore.
+func (store *MongoDBStore) DecodeID(r *http.Request, name string) (string, error) {
+	cookieName, encodedID, ok := store.readSessionID(r, name)
+	if !ok {
+		return "", ErrNoSessionID
+	}
+
+	sessionID, _, err := store.decodeCookieID(cookieName, encodedID)
+	if err != nil {
+		if mismatch := store.cookieNameMismatch(r, cookieName, encodedID); mismatch != nil {
+			return "", mismatch
+		}
+		return "", err
+	}
+
+	return sessionID, nil
+}
+
+//cookieNameMismatch returns an *InvalidCookieErr wrapping ErrCookieNameMismatch if
+//encodedID, which failed to decode for the session called name, decodes for the name of
+//another cookie of r or one of Options.LegacyCookieNames.  The codecs bind the name into
+//the signature, so only trying the other names tells a swapped cookie from a tampered one.
+func (store *MongoDBStore) cookieNameMismatch(r *http.Request, name, encodedID string) error {
+	candidates := store.legacyCookieNames()
+	for _, cookie := range r.Cookies() {
+		candidates = append(candidates, cookie.Name)
+	}
+
+	for _, candidate := range candidates {
+		if candidate == name {
+			continue
+		}
+		if _, err := store.idCodec().Decode(candidate, encodedID); err == nil {
+			_ = level.Debug(store.logger).Log(
+				"message", "session cookie was issued for another session name",
+				"name", name,
+				"issued_for", candidate,
+			)
+			return NewInvalidCookieErr(name, ErrCookieNameMismatch)
+		}
+	}
+
+	return nil
+}
+
+//decodeCookieID decodes the encoded ID of a session cookie called name, returning an
+//*InvalidCookieErr unless it holds a valid session ID
+func (store *MongoDBStore) decodeCookieID(name, encodedID string) (string, primitive.ObjectID, error) {
+	sessionID, err := store.idCodec().Decode(name, encodedID)
+	if err == nil && sessionID == "" {
+		err = errors.New("empty session ID")
+	}
+	if err != nil {
+		return "", primitive.NilObjectID, NewInvalidCookieErr(name, err)
+	}
+	oid, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return "", primitive.NilObjectID, NewInvalidCookieErr(name, NewInvalidSessionIDErr(sessionID, err))
+	}
+
+	return sessionID, oid, nil
+}
+
+//ValidateCookie checks that cookieValue, the value of the cookie of the session called
+//name, refers to a live session and returns it, e.g. for authentication middleware that
+//only has the raw cookie.  Expiry is always enforced, regardless of
+//TTLOptions.EnforceOnRead.  It returns an *InvalidCookieErr if the value cannot be
+//decoded, ErrSessionNotFound if no such session is stored and ErrSessionExpired if it has
+//expired.  The session is only loaded; it is neither refreshed nor cached in a Registry.
+func (store *MongoDBStore) ValidateCookie(ctx context.Context, name, cookieValue string) (*sessions.Session, error) {
+	logger := store.contextLogger(ctx)
+	sessionID, oid, err := store.decodeCookieID(name, cookieValue)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := store.findNamedSession(ctx, oid, name)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to load session to validate",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return nil, err
+	}
+	if !s.DeletedAt.IsZero() {
+		return nil, ErrSessionNotFound
+	}
+	if store.isExpired(s, name) {
+		return nil, ErrSessionExpired
+	}
+
+	sess := sessions.NewSession(store, name)
+	sess.ID = sessionID
+	sess.Options = derefOpts(store.defaultOptions)
+	if err = store.decodeValues(sess, s); err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to decode session values to validate",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return nil, err
+	}
+
+	return sess, nil
+}
@@ -0,0 +1,133 @@
+package sessions_mongo
+
+import (
+	"context"
+	"github.com/go-kit/kit/log/level"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//updateValuesAttempts bounds how often UpdateValues retries after losing a race
+const updateValuesAttempts = 3
+
+//UpdateValues changes the values of the stored session with the given ID and name without
+//a request, e.g. to revoke a role from the server side.  The session is loaded, mutate is
+//applied to its values and the result is written only if the stored session wasn't
+//modified in the meantime; otherwise the cycle is retried with the newer values, and
+//ErrConcurrentUpdate is returned when the session keeps being modified.  mutate may run
+//more than once and should only depend on the values it is given.  An error returned by
+//mutate aborts the update and is returned as is.
+//
+//ErrSessionNotFound is returned if the session doesn't exist or, with
+//TTLOptions.EnforceOnRead, has expired.  The name is required because the codecs bind it
+//into the encoded values.  UpdateValues is not supported with LayoutSubdocument or
+//CappedOptions.
+func (store *MongoDBStore) UpdateValues(
+	ctx context.Context,
+	name, sessionID string,
+	mutate func(values map[interface{}]interface{}) error,
+) error {
+	if store.storeOptions.Layout == LayoutSubdocument {
+		return NewIncompatibleOptionsErr("UpdateValues is not supported with LayoutSubdocument")
+	}
+	if store.storeOptions.CappedOptions.Enabled {
+		return NewIncompatibleOptionsErr("UpdateValues is not supported with CappedOptions")
+	}
+
+	logger := store.contextLogger(ctx)
+	for attempt := 0; attempt < updateValuesAttempts; attempt++ {
+		updated, err := store.updateValues(ctx, name, sessionID, mutate)
+		if err != nil {
+			return err
+		}
+		if updated {
+			return nil
+		}
+		_ = level.Debug(logger).Log(
+			"message", "session was modified while updating its values, retrying",
+			"session_id", sessionID,
+			"attempt", attempt+1,
+		)
+	}
+
+	return ErrConcurrentUpdate
+}
+
+//updateValues runs a single load, mutate and conditional write cycle of UpdateValues.  It
+//reports false if the stored session changed after it was loaded.
+func (store *MongoDBStore) updateValues(
+	ctx context.Context,
+	name, sessionID string,
+	mutate func(values map[interface{}]interface{}) error,
+) (bool, error) {
+	logger := store.contextLogger(ctx)
+	s, sess, err := store.loadStoredSession(ctx, name, sessionID)
+	if err != nil {
+		return false, err
+	}
+	if !s.DeletedAt.IsZero() ||
+		(store.storeOptions.TTLOptions.EnforceOnRead && store.isExpired(s, name)) {
+		return false, ErrSessionNotFound
+	}
+
+	if err = mutate(sess.Values); err != nil {
+		return false, err
+	}
+
+	updated, err := store.toDocument(sess)
+	if err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to encode updated session values",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return false, err
+	}
+	if store.storeOptions.SkipUnchangedWrites {
+		if updated.ValuesHash, err = hashValues(sess.Values); err != nil {
+			return false, err
+		}
+	}
+
+	c, err := store.collectionFor(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	//the encoded values and last_modified identify the version that was loaded;
+	//last_modified alone only has millisecond precision
+	filter := store.namedFilter(s.ObjectID(), name)
+	filter["data"] = s.Data
+	if len(s.Values) > 0 {
+		filter["values"] = s.Values
+	}
+	if s.LastModified.IsZero() {
+		filter["last_modified"] = bson.M{"$exists": false}
+	} else {
+		filter["last_modified"] = s.LastModified
+	}
+
+	var res *mongo.UpdateResult
+	update := updateDocFromSession(updated, store.unsetFields()...)
+	err = store.withRetry(ctx, func() error {
+		var err error
+		res, err = c.UpdateOne(ctx, filter, update)
+		return err
+	})
+	//a cached copy is stale after a write and may be why a lost race was lost
+	store.uncache(sessionID)
+	if isUnacknowledgedWrite(err) {
+		//a lost race cannot be detected without an acknowledgement
+		return true, nil
+	}
+	if err != nil {
+		_ = level.Error(logger).Log(
+			"message", "failed to update session values",
+			"session_id", sessionID,
+			"error", err,
+		)
+		return false, err
+	}
+
+	return res.MatchedCount > 0, nil
+}